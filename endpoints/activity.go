@@ -0,0 +1,122 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/abelana-gcp/third_party/redisx"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// The activity log is what other people have done to me.  The most recent maxActivity are in
+// AC:uuuuuu, and everything is in Datastore as User >> Activity for when we run out.  AU:uuuuuu
+// counts the ones I haven't read.
+const maxActivity = 200
+
+// recordActivity adds a to ownerID's activity log.
+func recordActivity(cx appengine.Context, ownerID string, a Activity) error {
+	a.Time = time.Now().UTC().UnixNano()
+	k := datastore.NewIncompleteKey(cx, "Activity", datastore.NewKey(cx, "User", ownerID, 0, nil))
+	if _, err := datastore.Put(cx, k, &a); err != nil {
+		return err
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	conn := pool.Get(cx)
+	defer conn.Close()
+
+	conn.Send("LPUSH", "AC:"+ownerID, b)
+	conn.Send("LTRIM", "AC:"+ownerID, 0, maxActivity-1)
+	conn.Send("INCR", "AU:"+ownerID)
+	_, err = conn.Do("")
+	return err
+}
+
+// GetActivity pages through what others have done to me, newest first.  Each page is the
+// activity before the Time, in nanoseconds, of the last one the client has (before) : Activities
+func GetActivity(cx appengine.Context, at Access, b Before, w http.ResponseWriter) error {
+	batch := abelanaConfig().TimelineBatchSize
+	before := int64(b)
+
+	conn := pool.Get(cx)
+	defer conn.Close()
+
+	list, err := redisx.Values(conn.Do("LRANGE", "AC:"+at.ID(), 0, -1))
+	if err != nil && err != redisx.ErrNil {
+		return serverError("Unable to get activity", err)
+	}
+	unread, err := redisx.Int(conn.Do("GET", "AU:"+at.ID()))
+	if err != nil && err != redisx.ErrNil {
+		return serverError("Unable to get activity", err)
+	}
+
+	var acts []Activity
+	for _, v := range list {
+		if len(acts) == batch {
+			break
+		}
+		b, ok := v.([]byte)
+		if !ok {
+			continue
+		}
+		var a Activity
+		if err := json.Unmarshal(b, &a); err != nil {
+			cx.Errorf("GetActivity: %v %v", at.ID(), err)
+			continue
+		}
+		if before == 0 || a.Time < before {
+			acts = append(acts, a)
+		}
+	}
+
+	// Redis only has the most recent, the rest are in Datastore.
+	if len(acts) < batch && len(list) == maxActivity {
+		if len(acts) > 0 {
+			before = acts[len(acts)-1].Time
+		}
+		q := datastore.NewQuery("Activity").Ancestor(datastore.NewKey(cx, "User", at.ID(), 0, nil))
+		if before != 0 {
+			q = q.Filter("Time <", before)
+		}
+		var more []Activity
+		if _, err := q.Order("-Time").Limit(batch-len(acts)).GetAll(cx, &more); err != nil {
+			return serverError("Unable to get activity", err)
+		}
+		acts = append(acts, more...)
+	}
+
+	replyJSON(w, &Activities{"abelana#activities", unread, acts})
+	return nil
+}
+
+// ReadActivity marks all my activity as read : Status
+func ReadActivity(cx appengine.Context, at Access, w http.ResponseWriter) error {
+	conn := pool.Get(cx)
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "AU:"+at.ID()); err != nil {
+		return serverError("Unable to mark activity read", err)
+	}
+	replyOk(w)
+	return nil
+}
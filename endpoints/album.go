@@ -0,0 +1,333 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/go-martini/martini"
+)
+
+// Albums live in Datastore as User >> Album, the photos are kept in the order the owner wants
+// them.  An album can be more private than its owner, but never less.
+const (
+	maxAlbumPhotos  = 1000
+	maxAlbumName    = 100
+	VisibilityOwner = "owner" // only the owner may see the album
+)
+
+// AlbumID is a validated :albumid
+type AlbumID string
+
+// withAlbum maps :albumid as an AlbumID
+func withAlbum(c martini.Context, p martini.Params) error {
+	if !idRE.MatchString(p["albumid"]) {
+		return badRequest("Invalid albumid")
+	}
+	c.Map(AlbumID(p["albumid"]))
+	return nil
+}
+
+func albumKey(cx appengine.Context, ownerID string, id AlbumID) *datastore.Key {
+	return datastore.NewKey(cx, "Album", string(id), 0, datastore.NewKey(cx, "User", ownerID, 0, nil))
+}
+
+// canSeeAlbum adds the album's own visibility to the owner's.
+func canSeeAlbum(owner *User, a *Album, viewerID string) bool {
+	if !canSee(owner, viewerID) {
+		return false
+	}
+	if owner.UserID == viewerID {
+		return true
+	}
+	switch a.Visibility {
+	case "", VisibilityPublic:
+		return true
+	case VisibilityFollowers:
+		return !uniqueP(owner.FollowsMe, viewerID)
+	case VisibilityApproved:
+		return !uniqueP(owner.FollowsMe, viewerID) && !uniqueP(owner.Approved, viewerID)
+	}
+	return false // VisibilityOwner
+}
+
+// cover is the photo to show for the album, the first one unless the owner picked another.
+func (a *Album) cover() string {
+	if a.Cover != "" || len(a.Photos) == 0 {
+		return a.Cover
+	}
+	return a.Photos[0]
+}
+
+// decodeAlbumRequest reads and checks what the client sent.
+func decodeAlbumRequest(rq *http.Request) (*AlbumRequest, error) {
+	var ar AlbumRequest
+	if err := json.NewDecoder(io.LimitReader(rq.Body, 64<<10)).Decode(&ar); err != nil {
+		return nil, badRequest("Invalid album")
+	}
+	if len(ar.Name) > maxAlbumName {
+		return nil, badRequest("Album name too long")
+	}
+	switch ar.Visibility {
+	case "", VisibilityPublic, VisibilityFollowers, VisibilityApproved, VisibilityOwner:
+	default:
+		return nil, badRequest("Invalid visibility")
+	}
+	return &ar, nil
+}
+
+// updateAlbum runs f on one of my albums in a transaction.
+func updateAlbum(cx appengine.Context, ownerID string, id AlbumID, f func(a *Album) error) (*Album, error) {
+	a := &Album{}
+	err := datastore.RunInTransaction(cx, func(cx appengine.Context) error {
+		k := albumKey(cx, ownerID, id)
+		if err := datastore.Get(cx, k, a); err != nil {
+			return err
+		}
+		if err := f(a); err != nil {
+			return err
+		}
+		_, err := datastore.Put(cx, k, a)
+		return err
+	}, nil)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, notFound("No such album", err)
+	}
+	if _, ok := err.(*apiError); ok {
+		return nil, err
+	}
+	if err != nil {
+		return nil, serverError("Unable to update album", err)
+	}
+	return a, nil
+}
+
+// CreateAlbum makes a new, empty, album (AlbumRequest) : Album
+func CreateAlbum(cx appengine.Context, at Access, rq *http.Request, w http.ResponseWriter) error {
+	ar, err := decodeAlbumRequest(rq)
+	if err != nil {
+		return err
+	}
+	if ar.Name == "" {
+		return badRequest("Album needs a name")
+	}
+	id, err := randomID()
+	if err != nil {
+		return serverError("Unable to create albumid", err)
+	}
+	a := &Album{
+		AlbumID:    id,
+		Name:       ar.Name,
+		Visibility: ar.Visibility,
+		Created:    time.Now().UTC().Unix(),
+	}
+	if _, err := datastore.Put(cx, albumKey(cx, at.ID(), AlbumID(id)), a); err != nil {
+		return serverError("Unable to create album", err)
+	}
+	a.Kind = "abelana#album"
+	replyJSON(w, a)
+	return nil
+}
+
+// UpdateAlbum renames the album, changes who can see it, or picks the cover (AlbumRequest) : Album
+func UpdateAlbum(cx appengine.Context, at Access, id AlbumID, rq *http.Request, w http.ResponseWriter) error {
+	ar, err := decodeAlbumRequest(rq)
+	if err != nil {
+		return err
+	}
+	a, err := updateAlbum(cx, at.ID(), id, func(a *Album) error {
+		if ar.Name != "" {
+			a.Name = ar.Name
+		}
+		if ar.Visibility != "" {
+			a.Visibility = ar.Visibility
+		}
+		if ar.Cover != "" {
+			if uniqueP(a.Photos, ar.Cover) {
+				return badRequest("Cover must be in the album")
+			}
+			a.Cover = ar.Cover
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	a.Kind = "abelana#album"
+	a.Cover = a.cover()
+	replyJSON(w, a)
+	return nil
+}
+
+// DeleteAlbum removes the album, but not its photos : Status
+func DeleteAlbum(cx appengine.Context, at Access, id AlbumID, w http.ResponseWriter) error {
+	if err := datastore.Delete(cx, albumKey(cx, at.ID(), id)); err != nil {
+		return serverError("Unable to delete album", err)
+	}
+	replyOk(w)
+	return nil
+}
+
+// AddToAlbum puts one of my photos at the end of the album (albumid, photoid) : Status
+func AddToAlbum(cx appengine.Context, at Access, id AlbumID, ph PhotoID, w http.ResponseWriter) error {
+	if ph.Owner != at.ID() {
+		return forbidden("Only your own photos")
+	}
+	if err := checkPhoto(cx, at.ID(), ph); err != nil {
+		return err
+	}
+	_, err := updateAlbum(cx, at.ID(), id, func(a *Album) error {
+		if !uniqueP(a.Photos, ph.ID) {
+			return nil
+		}
+		if len(a.Photos) >= maxAlbumPhotos {
+			return badRequest("Album is full")
+		}
+		a.Photos = append(a.Photos, ph.ID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	replyOk(w)
+	return nil
+}
+
+// RemoveFromAlbum takes a photo out of the album (albumid, photoid) : Status
+func RemoveFromAlbum(cx appengine.Context, at Access, id AlbumID, ph PhotoID, w http.ResponseWriter) error {
+	_, err := updateAlbum(cx, at.ID(), id, func(a *Album) error {
+		for i, p := range a.Photos {
+			if p == ph.ID {
+				a.Photos = append(a.Photos[:i], a.Photos[i+1:]...)
+				break
+			}
+		}
+		if a.Cover == ph.ID {
+			a.Cover = ""
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	replyOk(w)
+	return nil
+}
+
+// OrderAlbum puts the photos in a new order, which must have exactly the same photos
+// (AlbumOrder) : Status
+func OrderAlbum(cx appengine.Context, at Access, id AlbumID, rq *http.Request, w http.ResponseWriter) error {
+	var ao AlbumOrder
+	if err := json.NewDecoder(io.LimitReader(rq.Body, 256<<10)).Decode(&ao); err != nil {
+		return badRequest("Invalid order")
+	}
+	_, err := updateAlbum(cx, at.ID(), id, func(a *Album) error {
+		if len(ao.Photos) != len(a.Photos) {
+			return badRequest("Order must have every photo in the album")
+		}
+		seen := make(map[string]bool)
+		for _, p := range ao.Photos {
+			if seen[p] || uniqueP(a.Photos, p) {
+				return badRequest("Order must have every photo in the album")
+			}
+			seen[p] = true
+		}
+		a.Photos = ao.Photos
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	replyOk(w)
+	return nil
+}
+
+// GetMyAlbums lists my albums : AlbumList
+func GetMyAlbums(cx appengine.Context, at Access, w http.ResponseWriter) error {
+	return replyAlbums(cx, at.ID(), at.ID(), w)
+}
+
+// GetAlbums lists the albums of someone I follow that I'm allowed to see (personid) : AlbumList
+func GetAlbums(cx appengine.Context, at Access, id PersonID, w http.ResponseWriter) error {
+	return replyAlbums(cx, at.ID(), string(id), w)
+}
+
+func replyAlbums(cx appengine.Context, viewerID, ownerID string, w http.ResponseWriter) error {
+	owner, err := checkVisible(cx, viewerID, ownerID)
+	if err != nil {
+		return err
+	}
+	var albums []Album
+	q := datastore.NewQuery("Album").Ancestor(datastore.NewKey(cx, "User", ownerID, 0, nil))
+	if _, err := q.Order("-Created").GetAll(cx, &albums); err != nil {
+		return serverError("Unable to get albums", err)
+	}
+	al := AlbumList{Kind: "abelana#albumList"}
+	for i := range albums {
+		a := &albums[i]
+		if !canSeeAlbum(owner, a, viewerID) {
+			continue
+		}
+		a.Kind = "abelana#album"
+		a.Cover = a.cover()
+		a.Photos = nil // they get those from the album's timeline
+		al.Albums = append(al.Albums, *a)
+	}
+	replyJSON(w, al)
+	return nil
+}
+
+// GetMyAlbum is one of my albums, in order (albumid, lastid) : Timeline
+func GetMyAlbum(cx appengine.Context, at Access, id AlbumID, p martini.Params, w http.ResponseWriter, rq *http.Request) error {
+	return replyAlbum(cx, at.ID(), at.ID(), id, p["lastid"], w, rq)
+}
+
+// GetAlbum is an album of someone I follow, in order (personid, albumid, lastid) : Timeline
+func GetAlbum(cx appengine.Context, at Access, pid PersonID, id AlbumID, p martini.Params, w http.ResponseWriter, rq *http.Request) error {
+	return replyAlbum(cx, at.ID(), string(pid), id, p["lastid"], w, rq)
+}
+
+func replyAlbum(cx appengine.Context, viewerID, ownerID string, id AlbumID, lastid string, w http.ResponseWriter, rq *http.Request) error {
+	if lastid != "0" {
+		if _, err := parsePhotoID(lastid); err != nil {
+			return err
+		}
+	}
+	owner, err := checkVisible(cx, viewerID, ownerID)
+	if err != nil {
+		return err
+	}
+	var a Album
+	err = datastore.Get(cx, albumKey(cx, ownerID, id), &a)
+	if err == datastore.ErrNoSuchEntity {
+		return notFound("No such album", err)
+	}
+	if err != nil {
+		return serverError("Unable to get album", err)
+	}
+	if !canSeeAlbum(owner, &a, viewerID) {
+		return notFound("No such album", nil) // don't let on that it's there
+	}
+
+	conn := pool.Get(cx)
+	defer conn.Close()
+	return replyTimeline(w, rq, timelineEntries(cx, conn, viewerID, page(a.Photos, lastid), false))
+}
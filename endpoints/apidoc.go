@@ -0,0 +1,158 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// The OpenAPI (Swagger 2.0) document for each version is built from its route table the first
+// time someone asks for it.  Our handlers only ever take strings in the path, and for some, such
+// as login, albums and sharing, a JSON body, so that's all we describe.  Everything answers in
+// JSON but the event stream.
+
+type jsonObject map[string]interface{}
+
+// openAPIHandler serves the document for version.
+func openAPIHandler(version string, routes []route) func(http.ResponseWriter) {
+	var once sync.Once
+	var doc jsonObject
+	return func(w http.ResponseWriter) {
+		once.Do(func() { doc = openAPI(version, routes) })
+		replyJSON(w, doc)
+	}
+}
+
+// openAPI describes routes.
+func openAPI(version string, routes []route) jsonObject {
+	defs := jsonObject{}
+	paths := jsonObject{}
+	errSchema := schemaFor(reflect.TypeOf(Error{}), defs)
+
+	for _, e := range routes {
+		path, params := openAPIPath(e.Path)
+		if e.Request != nil {
+			params = append(params, jsonObject{
+				"name":     "body",
+				"in":       "body",
+				"required": true,
+				"schema":   schemaFor(reflect.TypeOf(e.Request), defs),
+			})
+		}
+		op := jsonObject{
+			"operationId": e.Name,
+			"parameters":  params,
+			"responses": jsonObject{
+				"200":     jsonObject{"description": "OK", "schema": schemaFor(reflect.TypeOf(e.Response), defs)},
+				"default": jsonObject{"description": "abelana#error", "schema": errSchema},
+			},
+		}
+		if e.Produces != "" {
+			op["produces"] = []string{e.Produces}
+		}
+		ops, ok := paths[path].(jsonObject)
+		if !ok {
+			ops = jsonObject{}
+			paths[path] = ops
+		}
+		ops[strings.ToLower(e.Method)] = op
+	}
+
+	return jsonObject{
+		"swagger":     "2.0",
+		"info":        jsonObject{"title": "Abelana", "version": version},
+		"basePath":    "/" + version,
+		"schemes":     []string{"https"},
+		"consumes":    []string{"application/json"},
+		"produces":    []string{"application/json"},
+		"paths":       paths,
+		"definitions": defs,
+	}
+}
+
+// paramDescriptions say what the path parameters that aren't ids mean.
+var paramDescriptions = map[string]string{
+	"lastid":   "the last photoid the client has, 0 for the newest",
+	"lastdate": "the date of the last photo the client has, in seconds since the epoch, 0 for the newest",
+	"before":   "the time of the last activity the client has, in nanoseconds since the epoch, 0 for the newest",
+}
+
+// openAPIPath turns a martini path into an OpenAPI one, and lists its parameters.
+func openAPIPath(p string) (string, []jsonObject) {
+	var params []jsonObject
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		if strings.HasPrefix(s, ":") {
+			name := s[1:]
+			segs[i] = "{" + name + "}"
+			param := jsonObject{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"type":     "string",
+			}
+			if d, ok := paramDescriptions[name]; ok {
+				param["description"] = d
+			}
+			params = append(params, param)
+		}
+	}
+	return strings.Join(segs, "/"), params
+}
+
+// schemaFor describes t, adding any structs to defs.
+func schemaFor(t reflect.Type, defs jsonObject) jsonObject {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem(), defs)
+	case reflect.String:
+		return jsonObject{"type": "string"}
+	case reflect.Bool:
+		return jsonObject{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return jsonObject{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return jsonObject{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return jsonObject{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return jsonObject{"type": "array", "items": schemaFor(t.Elem(), defs)}
+	case reflect.Map:
+		return jsonObject{"type": "object", "additionalProperties": schemaFor(t.Elem(), defs)}
+	case reflect.Struct:
+		ref := jsonObject{"$ref": "#/definitions/" + t.Name()}
+		if _, ok := defs[t.Name()]; ok {
+			return ref
+		}
+		props := jsonObject{}
+		defs[t.Name()] = jsonObject{"type": "object", "properties": props}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" || f.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaFor(f.Type, defs)
+		}
+		return ref
+	}
+	return jsonObject{}
+}
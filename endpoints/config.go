@@ -4,26 +4,46 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"log"
+	"sync"
 )
 
 // AbelanaConfig contains all the information we need to run Abelana
 type AbelanaConfig struct {
-	AuthEmail         string
-	ProjectID         string
-	Bucket            string
-	RedisPW           string
-	Redis             string
-	ServerKey         string
-	AutoFollowers     []string
-	Silhouette        string
-	TimelineBatchSize int
-	UploadRetries     int
-	EnableBackdoor    bool
+	AuthEmail          string
+	ProjectID          string
+	Bucket             string
+	ImageBucket        string              // where the resizer writes renditions
+	ImageFormats       map[string][]string // by rendition, the formats it's written in, if not webp and jpeg
+	RedisPW            string
+	Redis              string
+	AutoFollowers      []string
+	Silhouette         string
+	PhotoHosts         []string // hosts we will copy profile photos from
+	TimelineBatchSize  int
+	UploadRetries      int
+	MaxUploadBytes     int64                // largest photo a client may upload
+	UploadURLExpiry    int                  // seconds an upload URL is good for
+	RateLimits         map[string]RateLimit // by route: like, flag, comment, follow
+	EventStreamSeconds int                  // how long the events module keeps a stream open
+
+	// EnableTestIdentities replaces GitKit with the test provider, dev_appserver only.
+	EnableTestIdentities bool
+	TestIdentities       map[string]Identity
 }
 
-var config = mustLoadConfig("private/abelana-config.json")
+// config is read from private/abelana-config.json the first time it's needed, unless it has
+// already been set, as tests do.
+var (
+	config     *AbelanaConfig
+	configOnce sync.Once
+)
 
 func abelanaConfig() *AbelanaConfig {
+	configOnce.Do(func() {
+		if config == nil {
+			config = mustLoadConfig("private/abelana-config.json")
+		}
+	})
 	return config
 }
 
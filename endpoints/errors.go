@@ -0,0 +1,107 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"appengine"
+
+	"github.com/go-martini/martini"
+)
+
+// Our handlers return an error instead of writing one.  A nil error means the handler has already
+// replied.  Anything else is turned into an Error message by returnHandler, with the status from
+// an *apiError, or 500 if it's some other error.
+
+// apiError is an error we can explain to the client.  Err is the underlying cause, which we log
+// but never send.
+type apiError struct {
+	Code      int
+	Message   string
+	Retryable bool
+	Err       error
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+func badRequest(msg string) error {
+	return &apiError{Code: http.StatusBadRequest, Message: msg}
+}
+
+func unauthorized(msg string, err error) error {
+	return &apiError{Code: http.StatusUnauthorized, Message: msg, Err: err}
+}
+
+func forbidden(msg string) error {
+	return &apiError{Code: http.StatusForbidden, Message: msg}
+}
+
+func notFound(msg string, err error) error {
+	return &apiError{Code: http.StatusNotFound, Message: msg, Err: err}
+}
+
+// serverError is our fault, the client may try again.
+func serverError(msg string, err error) error {
+	return &apiError{Code: http.StatusInternalServerError, Message: msg, Retryable: true, Err: err}
+}
+
+var (
+	responseWriterType = reflect.TypeOf((*http.ResponseWriter)(nil)).Elem()
+	contextType        = reflect.TypeOf((*appengine.Context)(nil)).Elem()
+	errorType          = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// returnHandler replaces martini's, which would write our errors out as text.  It only expects
+// handlers that return a single error.
+func returnHandler(c martini.Context, vals []reflect.Value) {
+	if len(vals) != 1 || vals[0].Type() != errorType || vals[0].IsNil() {
+		return
+	}
+	w := c.Get(responseWriterType).Interface().(http.ResponseWriter)
+	cx := c.Get(contextType).Interface().(appengine.Context)
+	replyError(cx, w, vals[0].Interface().(error))
+}
+
+// replyError logs err and sends it to the client as an Error.
+func replyError(cx appengine.Context, w http.ResponseWriter, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		e = &apiError{Code: http.StatusInternalServerError, Message: "Internal error", Retryable: true, Err: err}
+	}
+	if e.Code >= http.StatusInternalServerError {
+		cx.Errorf("%v", e)
+	} else if DEBUG {
+		cx.Infof("%v", e)
+	}
+
+	b, err := json.Marshal(&Error{"abelana#error", e.Code, e.Message, e.Retryable})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code)
+	w.Write(b)
+}
@@ -0,0 +1,192 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/GoogleCloudPlatform/abelana-gcp/third_party/redisx"
+
+	"appengine"
+)
+
+// Events are published to CH:uuuuuu as they happen, and the last maxEvents are kept in EV:uuuuuu
+// so that a client that reconnects with a Last-Event-ID doesn't miss any.  Event IDs come from
+// EN:uuuuuu and only ever go up.
+const (
+	maxEvents          = 100
+	eventKeepAlive     = 25 * time.Second
+	defaultEventStream = 50 * time.Second // less than a frontend request deadline
+	eventsModule       = "events"
+)
+
+// Kinds of Event
+const (
+	EventPhoto    = "photo"    // someone I follow added a photo
+	EventLike     = "like"     // someone liked my photo
+	EventComment  = "comment"  // someone commented on my photo
+	EventFollow   = "follow"   // someone followed me
+	EventReshare  = "reshare"  // someone reshared my photo, or a photo to me
+	EventShare    = "share"    // someone shared a photo with just me
+	EventRejected = "rejected" // the resizer wouldn't take my upload
+)
+
+// publishEvent gives the event the next id for the user, remembers it, and publishes it.
+// KEYS are EN:, EV: and CH: for the user, ARGV the event and maxEvents.
+var publishEvent = redisx.NewScript(3, `
+local ev = cjson.decode(ARGV[1])
+ev.id = redis.call('INCR', KEYS[1])
+local s = cjson.encode(ev)
+redis.call('LPUSH', KEYS[2], s)
+redis.call('LTRIM', KEYS[2], 0, tonumber(ARGV[2]) - 1)
+redis.call('PUBLISH', KEYS[3], s)
+return ev.id
+`)
+
+// publish sends ev to each of the users, errors are logged as nobody is waiting on them.
+func publish(cx appengine.Context, ev Event, users ...string) {
+	if len(users) == 0 {
+		return
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		cx.Errorf("publish: %v", err)
+		return
+	}
+
+	conn := pool.Get(cx)
+	defer conn.Close()
+
+	for _, u := range users {
+		publishEvent.Send(conn, "EN:"+u, "EV:"+u, "CH:"+u, b, maxEvents)
+	}
+	if err := conn.Flush(); err != nil {
+		cx.Errorf("publish: flush %v", err)
+		return
+	}
+	for _, u := range users {
+		if _, err := conn.Receive(); err != nil {
+			cx.Errorf("publish: %v %v", u, err)
+		}
+	}
+}
+
+// Events streams what happens to the user as server-sent events.  Frontend instances buffer the
+// response and have a deadline, so there it's a long poll that ends after defaultEventStream.
+// The events module runs on a managed VM, and streams for EventStreamSeconds.
+// (Last-Event-ID) : text/event-stream
+func Events(cx appengine.Context, at Access, rq *http.Request, w http.ResponseWriter) error {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return serverError("Streaming not supported", nil)
+	}
+	var last int64
+	if id := rq.Header.Get("Last-Event-ID"); id != "" {
+		last, _ = strconv.ParseInt(id, 10, 64)
+	} else if id := rq.FormValue("lastEventId"); id != "" {
+		last, _ = strconv.ParseInt(id, 10, 64)
+	}
+
+	// Subscribe before we catch up, so we can't miss anything in between.
+	c, err := pool.Dial(cx)
+	if err != nil {
+		return serverError("Unable to connect", err)
+	}
+	psc := redisx.PubSubConn{Conn: c}
+	defer psc.Close()
+	if err := psc.Subscribe("CH:" + at.ID()); err != nil {
+		return serverError("Unable to subscribe", err)
+	}
+	msgs := make(chan []byte)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(msgs)
+		for {
+			switch v := psc.Receive().(type) {
+			case redisx.Message:
+				select {
+				case msgs <- v.Data:
+				case <-quit:
+					return
+				}
+			case error:
+				return
+			}
+		}
+	}()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// send writes an event, unless the client has already seen it.
+	send := func(b []byte) {
+		var ev Event
+		if err := json.Unmarshal(b, &ev); err != nil || ev.ID <= last {
+			return
+		}
+		last = ev.ID
+		fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, b)
+	}
+
+	if last > 0 {
+		conn := pool.Get(cx)
+		missed, err := redisx.Values(conn.Do("LRANGE", "EV:"+at.ID(), 0, -1))
+		conn.Close()
+		if err != nil && err != redisx.ErrNil {
+			cx.Errorf("Events: LRANGE %v %v", at.ID(), err)
+		}
+		for i := len(missed) - 1; i >= 0; i-- { // oldest first
+			if b, ok := missed[i].([]byte); ok {
+				send(b)
+			}
+		}
+	}
+	f.Flush()
+
+	d := defaultEventStream
+	if appengine.ModuleName(cx) == eventsModule && abelanaConfig().EventStreamSeconds > 0 {
+		d = time.Duration(abelanaConfig().EventStreamSeconds) * time.Second
+	}
+	done := time.After(d)
+	ping := time.NewTicker(eventKeepAlive)
+	defer ping.Stop()
+	var gone <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		gone = cn.CloseNotify()
+	}
+	for {
+		select {
+		case b, ok := <-msgs:
+			if !ok {
+				return nil // redis went away, the client will reconnect.
+			}
+			send(b)
+		case <-ping.C:
+			fmt.Fprint(w, ":\n\n")
+		case <-done:
+			return nil
+		case <-gone:
+			return nil
+		}
+		f.Flush()
+	}
+}
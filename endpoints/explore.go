@@ -0,0 +1,137 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/abelana-gcp/third_party/redisx"
+
+	"appengine"
+)
+
+// EX is the popular photos, a ZSET of public photos.  Rather than decay every score as time
+// passes, each like is worth twice as much as one a halfLife earlier, which ranks the same way.
+// Those weights soon grow past what a double can hold, so EX keeps the log2 of each photo's total
+// and likes are added in log space: log2(2^s + 2^w) = max + log2(1 + 2^(min-max)).
+const (
+	exploreKey      = "EX"
+	maxExplore      = 2000
+	exploreHalfLife = 24 * time.Hour
+	exploreLikesTTL = 30 * 24 * time.Hour // long after a photo has dropped out of EX
+)
+
+var exploreEpoch = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// exploreWeight is the log2 of what a like is worth at t.
+func exploreWeight(t time.Time) (float64, error) {
+	w := float64(t.Sub(exploreEpoch)) / float64(exploreHalfLife)
+	if math.IsInf(w, 0) || math.IsNaN(w) {
+		return 0, fmt.Errorf("explore weight %v at %v", w, t)
+	}
+	return w, nil
+}
+
+// exploreLike adds a like to a photo that's already in EX, photos that aren't public never get in
+// there.  EL:ppppppp remembers what each like was worth, so it can be taken back.  A score that
+// isn't finite, from before scores were logs, is started again from the like.
+// KEYS are EX and EL:, ARGV the photoID, the userID, the weight and how long to keep EL:.
+var exploreLike = redisx.NewScript(2, `
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score or redis.call('HSETNX', KEYS[2], ARGV[2], ARGV[3]) == 0 then
+	return false
+end
+redis.call('EXPIRE', KEYS[2], tonumber(ARGV[4]))
+local s, w = tonumber(score), tonumber(ARGV[3])
+if not s or s ~= s or math.abs(s) == math.huge then
+	s = w
+else
+	local hi, lo = math.max(s, w), math.min(s, w)
+	s = hi + math.log(1 + 2 ^ (lo - hi)) / math.log(2)
+end
+redis.call('ZADD', KEYS[1], s, ARGV[1])
+return tostring(s)
+`)
+
+// exploreUnlike takes back what the like was worth, log2(2^s - 2^w) = s + log2(1 - 2^(w-s)).
+// If that would leave nothing, or either isn't finite, the score is left as it is.
+// KEYS are EX and EL:, ARGV the photoID and the userID.
+var exploreUnlike = redisx.NewScript(2, `
+local w = tonumber(redis.call('HGET', KEYS[2], ARGV[2]))
+if not w then
+	return false
+end
+redis.call('HDEL', KEYS[2], ARGV[2])
+local s = tonumber(redis.call('ZSCORE', KEYS[1], ARGV[1]))
+if not s or s ~= s or w ~= w or math.abs(s) == math.huge or math.abs(w) == math.huge then
+	return false
+end
+local d = 1 - 2 ^ (w - s)
+if d <= 1e-9 then
+	return false
+end
+s = s + math.log(d) / math.log(2)
+redis.call('ZADD', KEYS[1], s, ARGV[1])
+return tostring(s)
+`)
+
+// exploreAdd puts a new public photo into EX, it starts out as if it had one like.
+func exploreAdd(conn redisx.Conn, photoID string, date int64) error {
+	w, err := exploreWeight(time.Unix(date, 0))
+	if err != nil {
+		return err
+	}
+	conn.Send("ZADD", exploreKey, w, photoID)
+	conn.Send("ZREMRANGEBYRANK", exploreKey, 0, -maxExplore-1)
+	_, err = conn.Do("")
+	return err
+}
+
+// exploreAddLike counts userID's like of photoID, now.
+func exploreAddLike(conn redisx.Conn, photoID, userID string) error {
+	w, err := exploreWeight(time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = exploreLike.Do(conn, exploreKey, "EL:"+photoID, photoID, userID, w, int(exploreLikesTTL/time.Second))
+	return err
+}
+
+// exploreTakeLike takes back userID's like of photoID.
+func exploreTakeLike(conn redisx.Conn, photoID, userID string) error {
+	_, err := exploreUnlike.Do(conn, exploreKey, "EL:"+photoID, photoID, userID)
+	return err
+}
+
+// exploreRemove takes a photo out, when it's been flagged.
+func exploreRemove(conn redisx.Conn, photoID string) error {
+	_, err := conn.Do("ZREM", exploreKey, photoID)
+	return err
+}
+
+// GetExplore is the most popular photos right now : Timeline
+func GetExplore(cx appengine.Context, at Access, w http.ResponseWriter, rq *http.Request) error {
+	conn := pool.Get(cx)
+	defer conn.Close()
+
+	list, err := redisx.Strings(conn.Do("ZREVRANGE", exploreKey, 0, abelanaConfig().TimelineBatchSize-1))
+	if err != nil && err != redisx.ErrNil {
+		return serverError("Unable to get popular photos", err)
+	}
+	return replyTimeline(w, rq, timelineEntries(cx, conn, at.ID(), list, false))
+}
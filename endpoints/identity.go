@@ -0,0 +1,140 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+
+	"appengine"
+
+	"github.com/google/identity-toolkit-go-client/gitkit"
+)
+
+// testTokenPrefix is how a client asks the test provider for an arbitrary user, ie. "test:00042"
+const testTokenPrefix = "test:"
+
+var errNotDevServer = errors.New("test identities are only available on the dev_appserver")
+
+// Identity is what we know about a user once their login token has been validated.
+type Identity struct {
+	UserID        string
+	Email         string
+	EmailVerified bool
+	ProviderID    string
+	DisplayName   string // optional, overrides what the client sent us
+	PhotoURL      string // optional, overrides what the client sent us
+}
+
+// IdentityProvider validates the token a client presents to Login.  A token that is simply
+// not valid is reported as a TokenError, anything else means we couldn't check it.
+type IdentityProvider interface {
+	Validate(cx appengine.Context, token string) (*Identity, error)
+}
+
+// TokenError tells Login the client gave us a bad token, rather than we failed to check it.
+type TokenError struct {
+	Err error
+}
+
+func (e TokenError) Error() string {
+	return "invalid token: " + e.Err.Error()
+}
+
+// gitkitProvider validates tokens using the Google Identity Toolkit.
+type gitkitProvider struct {
+	client *gitkit.Client
+}
+
+// Validate checks the GitKit token
+func (g *gitkitProvider) Validate(cx appengine.Context, token string) (*Identity, error) {
+	client, err := gitkit.NewWithContext(cx, g.client)
+	if err != nil {
+		return nil, fmt.Errorf("gitkit.NewWithContext: %v", err)
+	}
+	tok, err := client.ValidateToken(token)
+	if err != nil {
+		if fetchFailed(err) {
+			return nil, fmt.Errorf("gitkit.ValidateToken: %v", err)
+		}
+		return nil, TokenError{err}
+	}
+	return &Identity{
+		UserID:        tok.LocalID,
+		Email:         tok.Email,
+		EmailVerified: tok.EmailVerified,
+		ProviderID:    tok.ProviderID,
+	}, nil
+}
+
+// fetchFailed is whether ValidateToken failed fetching GitKit's certificates, which is our
+// problem, rather than because the token didn't check out.
+func fetchFailed(err error) bool {
+	switch err.(type) {
+	case *url.Error, net.Error:
+		return true
+	}
+	return false
+}
+
+// testProvider lets us log in as any user without GitKit.  Tokens are either a key of
+// AbelanaConfig.TestIdentities, which gives the configured claims, or "test:<userid>" which
+// makes up a user on the fly.  It refuses to work anywhere but the dev_appserver.
+type testProvider struct {
+	identities map[string]Identity
+}
+
+// Validate looks up the test user
+func (t *testProvider) Validate(cx appengine.Context, token string) (*Identity, error) {
+	if !appengine.IsDevAppServer() {
+		return nil, errNotDevServer
+	}
+	if id, ok := t.identities[token]; ok {
+		if id.UserID == "" {
+			return nil, fmt.Errorf("test identity %q has no UserID", token)
+		}
+		return &id, nil
+	}
+	if strings.HasPrefix(token, testTokenPrefix) {
+		userID := strings.TrimPrefix(token, testTokenPrefix)
+		if userID == "" {
+			return nil, TokenError{errors.New("empty test user")}
+		}
+		return &Identity{
+			UserID:        userID,
+			Email:         userID + "@example.com",
+			EmailVerified: true,
+			ProviderID:    "test",
+			DisplayName:   "Test User " + userID,
+		}, nil
+	}
+	return nil, TokenError{fmt.Errorf("unknown test identity %q", token)}
+}
+
+// newIdentityProvider picks the provider for this instance.  Asking for test identities on
+// production is a configuration error, and we refuse to start.
+func newIdentityProvider(cfg *AbelanaConfig, client *gitkit.Client) IdentityProvider {
+	if !cfg.EnableTestIdentities {
+		return &gitkitProvider{client}
+	}
+	if !appengine.IsDevAppServer() {
+		log.Fatalf("EnableTestIdentities is set, but we are not on the dev_appserver")
+	}
+	return &testProvider{cfg.TestIdentities}
+}
@@ -0,0 +1,174 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-martini/martini"
+
+	"appengine"
+)
+
+// The resizer writes each rendition of a photo as photoid_rendition.ext, in each of the formats
+// renditions.json gives it.  By default that's WebP, and JPEG for the clients that can't show
+// WebP.  Older iOS and some browsers can't.
+
+// defaultImageBucket is where the resizer writes renditions, if AbelanaConfig doesn't say.
+const defaultImageBucket = "abelana"
+
+type imageFormat struct{ name, ext, contentType string }
+
+// imageFormats are the formats the resizer can make, best first.
+var imageFormats = []imageFormat{
+	{"webp", "webp", "image/webp"},
+	{"jpeg", "jpg", "image/jpeg"},
+	{"png", "png", "image/png"},
+}
+
+// defaultImageFormats are what a rendition is written in unless AbelanaConfig.ImageFormats says.
+var defaultImageFormats = []string{"webp", "jpeg"}
+
+var renditionRE = regexp.MustCompile(`^[A-Za-z0-9]{1,16}$`)
+
+// GetImage is where to get a rendition of a photo in a format the client can show.  A client may
+// list the formats it takes in ?formats=webp,jpeg, otherwise we go by its Accept header.  Asking
+// for a timeline with ?rendition= gives the same for each of its photos, without a call for each.
+// (photoid, rendition) : Image
+func GetImage(cx appengine.Context, at Access, ph PhotoID, p martini.Params, w http.ResponseWriter, rq *http.Request) error {
+	rendition := p["rendition"]
+	if !renditionRE.MatchString(rendition) {
+		return badRequest("Invalid rendition")
+	}
+	if err := checkPhoto(cx, at.ID(), ph); err != nil {
+		return err
+	}
+	f, err := chooseFormat(rq, rendition)
+	if err != nil {
+		return err
+	}
+	w.Header().Add("Vary", "Accept")
+	replyJSON(w, newImage(ph.ID, rendition, f))
+	return nil
+}
+
+// replyTimeline sends tl.  If the client asked for a ?rendition=, each entry says where to get it
+// in a format the client can show, as GetImage would.
+func replyTimeline(w http.ResponseWriter, rq *http.Request, tl []TLEntry) error {
+	if rendition := rq.FormValue("rendition"); rendition != "" {
+		if !renditionRE.MatchString(rendition) {
+			return badRequest("Invalid rendition")
+		}
+		f, err := chooseFormat(rq, rendition)
+		if err != nil {
+			return err
+		}
+		for i := range tl {
+			tl[i].Image = newImage(tl[i].PhotoID, rendition, f)
+		}
+		w.Header().Add("Vary", "Accept")
+	}
+	replyJSON(w, Timeline{"abelana#timeline", tl})
+	return nil
+}
+
+// chooseFormat picks the best of rendition's formats that the client can show.
+func chooseFormat(rq *http.Request, rendition string) (imageFormat, error) {
+	fs := renditionFormats(rendition)
+	if len(fs) == 0 {
+		return imageFormat{}, serverError("No known formats for rendition "+rendition, nil)
+	}
+	for _, f := range fs {
+		if acceptsImage(rq, f.name, f.contentType) {
+			return f, nil
+		}
+	}
+	return fs[len(fs)-1], nil
+}
+
+func newImage(photoID, rendition string, f imageFormat) *Image {
+	return &Image{
+		Kind:        "abelana#image",
+		URL:         imageURL(photoID, rendition, f.ext),
+		ContentType: f.contentType,
+	}
+}
+
+// renditionFormats are the formats rendition is written in, best first.
+func renditionFormats(rendition string) []imageFormat {
+	names, ok := abelanaConfig().ImageFormats[rendition]
+	if !ok {
+		names = defaultImageFormats
+	}
+	var fs []imageFormat
+	for _, f := range imageFormats {
+		for _, n := range names {
+			if n == f.name {
+				fs = append(fs, f)
+				break
+			}
+		}
+	}
+	return fs
+}
+
+// imageURL is where the resizer put a rendition of a photo.
+func imageURL(photoID, rendition, ext string) string {
+	bucket := abelanaConfig().ImageBucket
+	if bucket == "" {
+		bucket = defaultImageBucket
+	}
+	return "https://storage.googleapis.com/" + bucket + "/" + photoID + "_" + rendition + "." + ext
+}
+
+// acceptsImage is true if the client said it can show format, either in ?formats= or, failing
+// that, in its Accept header, where image/* and */* take any format that isn't named with q=0.
+// A JPEG is always acceptable, everyone can show those.
+func acceptsImage(rq *http.Request, format, contentType string) bool {
+	if fs := rq.FormValue("formats"); fs != "" {
+		for _, f := range strings.Split(fs, ",") {
+			if strings.TrimSpace(f) == format {
+				return true
+			}
+		}
+		return false
+	}
+	if contentType == "image/jpeg" {
+		return true
+	}
+	wildcard := false
+	for _, a := range strings.Split(rq.Header.Get("Accept"), ",") {
+		params := strings.Split(a, ";")
+		t := strings.TrimSpace(params[0])
+		if t != contentType && t != "image/*" && t != "*/*" {
+			continue
+		}
+		ok := true
+		for _, q := range params[1:] {
+			v := strings.TrimSpace(q)
+			if n, err := strconv.ParseFloat(strings.TrimPrefix(v, "q="), 64); strings.HasPrefix(v, "q=") && err == nil && n == 0 {
+				ok = false // q=0 means not acceptable
+			}
+		}
+		if t == contentType {
+			return ok // naming the type beats a wildcard
+		}
+		wildcard = wildcard || ok
+	}
+	return wildcard
+}
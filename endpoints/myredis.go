@@ -73,8 +73,13 @@ func newPool(server, password string) *redisx.Pool {
 // iNowFollow is Called when the user wants to follow someone (usually called from delay,
 // called from createUser x3 -- Goal Fixup the timeline
 func iNowFollow(cx appengine.Context, userID, followerID string) error {
-	var err error
-	var count int
+	followed, err := findUser(cx, followerID)
+	if err != nil {
+		return fmt.Errorf("iNowFollow findUser %v %v", followerID, err)
+	}
+	if !canSee(followed, userID) {
+		return nil // we'll be back when they approve us
+	}
 	k := datastore.NewKey(cx, "User", followerID, 0, nil)
 	q := datastore.NewQuery("Photo").Ancestor(k).Order("-Date").Limit(10)
 	var photos []Photo
@@ -87,18 +92,15 @@ func iNowFollow(cx appengine.Context, userID, followerID string) error {
 	defer conn.Close()
 
 	// The ideal algorithm would be to merge in date order, but instead, we just add the last 10.
+	if err := timelinePush.Load(conn); err != nil {
+		cx.Errorf("iNowFollow: %v", err)
+		return nil
+	}
 	for _, p := range photos {
-		count, err = redisx.Int(conn.Do("LPUSH", "TL:"+userID, p.PhotoID))
-		if err != nil {
-			cx.Errorf("iNowFollow: %v", err)
-		}
+		sendTimelinePush(conn, userID, p.PhotoID, "")
 	}
-	if err == nil && count > 2000 {
-		for i := 2000; i < count; i++ {
-			if _, err := conn.Do("RPOP", "TL:"+userID); err != nil {
-				cx.Errorf("iNowFollow: RPOP TL:%v %v", userID, err)
-			}
-		}
+	if _, err := conn.Do(""); err != nil {
+		cx.Errorf("iNowFollow: %v", err)
 	}
 	return nil
 }
@@ -108,7 +110,7 @@ func initialPhotos(cx appengine.Context, ID string) error {
 	conn := pool.Get(cx)
 	defer conn.Close()
 
-	_, err := conn.Do("LPUSH", "TL:"+ID, "0001.0001")
+	_, err := timelinePush.Do(conn, "TL:"+ID, "RS:"+ID, "0001.0001", "", maxTimeline)
 	if err != nil {
 		cx.Errorf("initialPhotos %v", err)
 	}
@@ -116,8 +118,23 @@ func initialPhotos(cx appengine.Context, ID string) error {
 	return nil // don't retry this.
 }
 
-// addPhoto is called to add a photo. This is allways called from a Delay
+// rejectPhoto tells the owner the resizer wouldn't take their upload, which it has deleted, so
+// their client can say so rather than wait for a photo that will never arrive.
+func rejectPhoto(cx appengine.Context, photoID, reason string) {
+	cx.Infof("rejectPhoto: %v %v", photoID, reason)
+	owner := strings.Split(photoID, ".")[0]
+	publish(cx, Event{Type: EventRejected, UserID: owner, PhotoID: photoID, Reason: reason, Time: time.Now().UTC().Unix()}, owner)
+}
+
+// addPhoto is what tasks queued before addPhotoInfo call, from before the resizer told us anything
+// about a photo.
 func addPhoto(cx appengine.Context, photoID string) error {
+	return addPhotoInfo(cx, photoID, PhotoInfo{})
+}
+
+// addPhotoInfo is called to add a photo, with what the resizer told us about it. This is allways
+// called from a Delay
+func addPhotoInfo(cx appengine.Context, photoID string, info PhotoInfo) error {
 	var u *User
 	var err error
 
@@ -125,16 +142,39 @@ func addPhoto(cx appengine.Context, photoID string) error {
 
 	// s[0] = userid, s[1] = random photo id
 	userID := s[0]
-	p := &Photo{photoID, time.Now().UTC().Unix()}
+	p := &Photo{
+		PhotoID:     photoID,
+		Date:        time.Now().UTC().Unix(),
+		Width:       info.Width,
+		Height:      info.Height,
+		Taken:       info.Taken,
+		Lat:         info.Lat,
+		Lng:         info.Lng,
+		HasLocation: info.HasLocation,
+	}
 	if userID != "0001" {
 		u, err = findUser(cx, userID)
 		if err != nil {
-			return fmt.Errorf("addPhoto: unable to find user %v %v", userID, err)
+			return fmt.Errorf("addPhotoInfo: unable to find user %v %v", userID, err)
 		}
+		// We may be called more than once for a photo, the first call's Photo is the one we keep.
 		k := datastore.NewKey(cx, "Photo", photoID, 0,
 			datastore.NewKey(cx, "User", userID, 0, nil))
-		if _, err := datastore.Put(cx, k, p); err != nil {
-			return fmt.Errorf("addPhoto: put photo in datastore %v", err)
+		err = datastore.RunInTransaction(cx, func(cx appengine.Context) error {
+			var old Photo
+			err := datastore.Get(cx, k, &old)
+			if err == nil {
+				p.Date = old.Date
+				return nil
+			}
+			if err != datastore.ErrNoSuchEntity {
+				return err
+			}
+			_, err = datastore.Put(cx, k, p)
+			return err
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("addPhotoInfo: put photo in datastore %v", err)
 		}
 	}
 
@@ -143,36 +183,68 @@ func addPhoto(cx appengine.Context, photoID string) error {
 
 	set, err := redisx.Int(conn.Do("HSETNX", "IM:"+photoID, "date", p.Date)) // Set Date
 	if (err != nil && err != redisx.ErrNil) || set == 0 {
-		cx.Infof("addPhoto: duplicate %v %v", err, set)
+		cx.Infof("addPhotoInfo: duplicate %v %v", err, set)
 		return nil // returning the error here makes TaskQ call us a lot.
 	}
+	if info.Width > 0 && info.Height > 0 {
+		if _, err := conn.Do("HMSET", "IM:"+photoID, "w", info.Width, "h", info.Height); err != nil {
+			cx.Errorf("addPhotoInfo: size %v %v", photoID, err)
+		}
+	}
 	// TODO: Consider if these should be done in batches of 100 or so.
 
 	if userID != "0001" {
-		list := append(u.FollowsMe, userID) // Make sure I can see the photo...
+		list := append(audience(u), userID) // Make sure I can see the photo...
 		// Add to each follower's list
-		for _, f := range list {
-			conn.Send("LPUSH", "TL:"+f, photoID)
-		}
-		conn.Flush()
-
-		// Check the result and adjust list if nescessary.
-		for _, f := range list {
-			v, err := redisx.Int(conn.Receive())
-			if err != nil && err != redisx.ErrNil {
-				cx.Errorf("addPhoto: get TL:%v %v", f, err)
-				continue
+		if err := timelinePush.Load(conn); err != nil {
+			cx.Errorf("addPhotoInfo: %v", err)
+		} else {
+			for _, f := range list {
+				sendTimelinePush(conn, f, photoID, "")
 			}
-			if v > 2000 {
-				if _, err := conn.Do("RPOP", "TL:"+f); err != nil {
-					cx.Errorf("addPhoto: RPOP TL:%v %v", f, err)
-				}
+			if _, err := conn.Do(""); err != nil {
+				cx.Errorf("addPhotoInfo: TL: %v %v", photoID, err)
+			}
+		}
+		publish(cx, Event{Type: EventPhoto, UserID: userID, PhotoID: photoID, Time: p.Date}, list[:len(list)-1]...)
+		if u.Visibility == "" || u.Visibility == VisibilityPublic {
+			if err := exploreAdd(conn, photoID, p.Date); err != nil {
+				cx.Errorf("addPhotoInfo: explore %v %v", photoID, err)
 			}
 		}
 	}
 	return nil
 }
 
+// maxTimeline is how many photos a timeline holds.
+const maxTimeline = 2000
+
+// timelinePush puts a photo at the top of a timeline, taking it out of wherever else it was so a
+// timeline never has a photo twice.  RS: says who reshared it, if anyone did, and forgets the
+// photos that fall off the end of the timeline.
+// KEYS are TL: and RS: for the user, ARGV the photoID, who reshared it or "", and maxTimeline.
+var timelinePush = redisx.NewScript(2, `
+redis.call('LREM', KEYS[1], 0, ARGV[1])
+redis.call('LPUSH', KEYS[1], ARGV[1])
+if ARGV[2] ~= '' then
+	redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+else
+	redis.call('HDEL', KEYS[2], ARGV[1])
+end
+local max = tonumber(ARGV[3])
+for _, id in ipairs(redis.call('LRANGE', KEYS[1], max, -1)) do
+	redis.call('HDEL', KEYS[2], id)
+end
+redis.call('LTRIM', KEYS[1], 0, max - 1)
+return redis.call('LLEN', KEYS[1])
+`)
+
+// sendTimelinePush sends timelinePush for userID's timeline without waiting for the reply.  The
+// script must have been loaded on conn.
+func sendTimelinePush(conn redisx.Conn, userID, photoID, resharer string) error {
+	return timelinePush.SendHash(conn, "TL:"+userID, "RS:"+userID, photoID, resharer, maxTimeline)
+}
+
 // getTimeline returns the user's Timeline, you could insert additional things here as well.
 func getTimeline(cx appengine.Context, userID, lastid string) ([]TLEntry, error) {
 	conn := pool.Get(cx)
@@ -182,8 +254,15 @@ func getTimeline(cx appengine.Context, userID, lastid string) ([]TLEntry, error)
 	if err != nil && err != redisx.ErrNil {
 		cx.Errorf("GetTimeLine %v", err)
 	}
-	ix := 0
+	tl := timelineEntries(cx, conn, userID, page(list, lastid), false)
+	addResharers(cx, conn, userID, tl)
+	return tl, nil
+}
 
+// page finds the photoIDs from lastid on, where the next batch starts.  TimeLineBatchSize is our
+// paging mechanism, timelineEntries will only return this many images.  The user can ask for more.
+func page(list []string, lastid string) []string {
+	ix := 0
 	if lastid != "0" { // if we aren't the first time, search for the next batch
 		for i, item := range list {
 			if item == lastid {
@@ -192,17 +271,48 @@ func getTimeline(cx appengine.Context, userID, lastid string) ([]TLEntry, error)
 			}
 		}
 	}
+	return list[ix:]
+}
+
+// timelineEntries looks up what userID should see for the first TimelineBatchSize of the
+// photoIDs they may, leaving out what they aren't allowed to see and what has been flagged.  It
+// reads on past those, as a short batch tells the client it has reached the end.  Photos that
+// were shared with userID may be seen whatever the owner's visibility.
+func timelineEntries(cx appengine.Context, conn redisx.Conn, userID string, photoIDs []string, shared bool) []TLEntry {
 	var timeline []TLEntry
-	// TimeLineBatchSize is our paging mechanism, we will only return this many images.  The user
-	// can ask for more.
-	for i := 0; i < abelanaConfig().TimelineBatchSize && i+ix < len(list); i++ {
-		photoID := list[ix+i]
+	owners := make(map[string]bool) // can we see them?
+	for _, photoID := range photoIDs {
+		if len(timeline) >= abelanaConfig().TimelineBatchSize {
+			break
+		}
+		if isDup(timeline, photoID) {
+			continue // followed, unfollowed, and followed again
+		}
+		s := strings.Split(photoID, ".")
+		visible, ok := owners[s[0]]
+		if !ok {
+			visible = s[0] == systemUserID || shared
+			if !visible {
+				u, err := findUser(cx, s[0])
+				if err != nil && err != datastore.ErrNoSuchEntity {
+					cx.Errorf("GetTimeLine findUser %v %v", s[0], err)
+				}
+				visible = err == nil && canSee(u, userID)
+			}
+			owners[s[0]] = visible
+		}
+		if !visible {
+			continue // they've made their photos private since this was added
+		}
 
-		v, err := redisx.Strings(conn.Do("HMGET", "IM:"+photoID, "date", userID, "flag"))
+		v, err := redisx.Strings(conn.Do("HMGET", "IM:"+photoID, "date", userID, "flag", "w", "h"))
 		if err != nil && err != redisx.ErrNil {
 			cx.Errorf("GetTimeLine HMGET %v", err)
 		}
-		if len(v) > 2 && v[2] != "" {
+		for len(v) < 5 {
+			v = append(v, "")
+		}
+		if v[2] != "" {
 			flags, err := strconv.Atoi(v[2])
 			if err == nil && flags > 1 {
 				continue // skip flag'd images
@@ -213,9 +323,12 @@ func getTimeline(cx appengine.Context, userID, lastid string) ([]TLEntry, error)
 			cx.Errorf("GetTimeLine HLEN %v", err)
 			likes = 0
 		} else {
-			likes = likes - 1 // offset as there is a Date as well
+			for _, f := range []string{v[0], v[2], v[3], v[4]} { // date, flag, w & h aren't likes
+				if f != "" {
+					likes--
+				}
+			}
 		}
-		s := strings.Split(photoID, ".")
 		dn, err := redisx.String(conn.Do("HGET", "HT:"+s[0], "dn"))
 		if err != nil && err != redisx.ErrNil {
 			cx.Errorf("GetTimeLine HGET %v", err)
@@ -225,10 +338,21 @@ func getTimeline(cx appengine.Context, userID, lastid string) ([]TLEntry, error)
 		if err != nil {
 			dt = 1414883602 // Nov 1, 2014
 		}
-		te := TLEntry{dt, s[0], dn, photoID, likes, v[1] == "1"}
+		te := TLEntry{Created: dt, UserID: s[0], Name: dn, PhotoID: photoID, Likes: likes, ILike: v[1] == "1"}
+		te.Width, _ = strconv.Atoi(v[3])
+		te.Height, _ = strconv.Atoi(v[4])
+		te.Aspect = aspect(te.Width, te.Height)
 		timeline = append(timeline, te)
 	}
-	return timeline, nil
+	return timeline
+}
+
+// aspect is width / height, 0 if we don't know.
+func aspect(width, height int) float64 {
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+	return float64(width) / float64(height)
 }
 
 func isDup(tl []TLEntry, id string) bool {
@@ -279,6 +403,9 @@ func like(cx appengine.Context, userID, photoID string) error {
 	if err != nil && err != redisx.ErrNil {
 		return fmt.Errorf("like %v", err)
 	}
+	if err := exploreAddLike(conn, photoID, userID); err != nil {
+		cx.Errorf("like: explore %v %v", photoID, err)
+	}
 	return nil
 }
 
@@ -291,6 +418,9 @@ func unlike(cx appengine.Context, userID, photoID string) error {
 	if err != nil && err != redisx.ErrNil {
 		return fmt.Errorf("unlike %v", err)
 	}
+	if err := exploreTakeLike(conn, photoID, userID); err != nil {
+		cx.Errorf("unlike: explore %v %v", photoID, err)
+	}
 	return nil
 }
 
@@ -299,9 +429,14 @@ func flag(cx appengine.Context, userID, photoID string) error {
 	conn := pool.Get(cx)
 	defer conn.Close()
 
-	_, err := redisx.Int(conn.Do("HINCRBY", "IM:"+photoID, "flag", 1))
+	flags, err := redisx.Int(conn.Do("HINCRBY", "IM:"+photoID, "flag", 1))
 	if err != nil && err != redisx.ErrNil {
 		return fmt.Errorf("unlike %v", err)
 	}
+	if flags > 1 { // the same as getTimeline
+		if err := exploreRemove(conn, photoID); err != nil {
+			cx.Errorf("flag: explore %v %v", photoID, err)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,122 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/go-martini/martini"
+)
+
+// Route parameters are parsed and checked by the middleware here, which maps the typed value
+// into the martini context for the handler.  A malformed parameter is a 400.
+
+var idRE = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// PersonID is a validated :personid
+type PersonID string
+
+// LastDate is a validated :lastdate, in seconds since the epoch, 0 means from the start.
+type LastDate int64
+
+// Before is a validated :before, the Time of the last Activity a client has, in nanoseconds since
+// the epoch, 0 means from the start.
+type Before int64
+
+// PhotoID is a validated :photoid, which is always userid.random
+type PhotoID struct {
+	Owner string // the userid part
+	ID    string // the whole thing
+}
+
+// Key is where the photo lives in Datastore.
+func (p PhotoID) Key(cx appengine.Context) *datastore.Key {
+	return datastore.NewKey(cx, "Photo", p.ID, 0, datastore.NewKey(cx, "User", p.Owner, 0, nil))
+}
+
+// parseUserID checks a user id
+func parseUserID(s string) (string, error) {
+	if !idRE.MatchString(s) {
+		return "", badRequest("Invalid personid")
+	}
+	return s, nil
+}
+
+// parsePhotoID checks a photo id
+func parsePhotoID(s string) (PhotoID, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 || !idRE.MatchString(parts[0]) || !idRE.MatchString(parts[1]) {
+		return PhotoID{}, badRequest("Invalid photoid")
+	}
+	return PhotoID{parts[0], s}, nil
+}
+
+// parseDate checks a date, which is a count since the epoch: seconds for :lastdate, nanoseconds
+// for :before.  "" is the same as 0.
+func parseDate(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || d < 0 {
+		return 0, badRequest("Invalid date")
+	}
+	return d, nil
+}
+
+// withPerson maps :personid as a PersonID
+func withPerson(c martini.Context, p martini.Params) error {
+	id, err := parseUserID(p["personid"])
+	if err != nil {
+		return err
+	}
+	c.Map(PersonID(id))
+	return nil
+}
+
+// withPhoto maps :photoid as a PhotoID
+func withPhoto(c martini.Context, p martini.Params) error {
+	ph, err := parsePhotoID(p["photoid"])
+	if err != nil {
+		return err
+	}
+	c.Map(ph)
+	return nil
+}
+
+// withLastDate maps :lastdate as a LastDate
+func withLastDate(c martini.Context, p martini.Params) error {
+	d, err := parseDate(p["lastdate"])
+	if err != nil {
+		return err
+	}
+	c.Map(LastDate(d))
+	return nil
+}
+
+// withBefore maps :before as a Before
+func withBefore(c martini.Context, p martini.Params) error {
+	d, err := parseDate(p["before"])
+	if err != nil {
+		return err
+	}
+	c.Map(Before(d))
+	return nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"net/http"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/go-martini/martini"
+)
+
+// Who may see a user's photos and profile.  An empty Visibility is public, which is how all
+// accounts started out.  Followers-only and approved-followers accounts are private.
+const (
+	VisibilityPublic    = "public"
+	VisibilityFollowers = "followers" // anyone who follows me
+	VisibilityApproved  = "approved"  // only followers I have approved
+)
+
+// systemUserID owns the welcome photos, which everyone can see.
+const systemUserID = "0001"
+
+// canSee is the visibility policy, it tells us if viewerID may see what owner has shared.
+func canSee(owner *User, viewerID string) bool {
+	if owner.UserID == viewerID {
+		return true
+	}
+	if !uniqueP(owner.Blocked, viewerID) {
+		return false
+	}
+	switch owner.Visibility {
+	case "", VisibilityPublic:
+		return true
+	case VisibilityFollowers:
+		return !uniqueP(owner.FollowsMe, viewerID)
+	case VisibilityApproved:
+		return !uniqueP(owner.FollowsMe, viewerID) && !uniqueP(owner.Approved, viewerID)
+	}
+	return false
+}
+
+// audience is who should get owner's new photos in their timeline.
+func audience(owner *User) []string {
+	var list []string
+	for _, f := range owner.FollowsMe {
+		if canSee(owner, f) {
+			list = append(list, f)
+		}
+	}
+	return list
+}
+
+// checkVisible finds ownerID and makes sure viewerID may see them.
+func checkVisible(cx appengine.Context, viewerID, ownerID string) (*User, error) {
+	u, err := findUser(cx, ownerID)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, notFound("No such person", err)
+	}
+	if err != nil {
+		return nil, serverError("Unable to get person", err)
+	}
+	if !canSee(u, viewerID) {
+		return nil, forbidden("Not allowed")
+	}
+	return u, nil
+}
+
+// checkPhoto makes sure the photo exists and viewerID may see it, or it was shared with them.
+func checkPhoto(cx appengine.Context, viewerID string, ph PhotoID) error {
+	if ph.Owner == systemUserID {
+		return nil
+	}
+	if _, err := checkVisible(cx, viewerID, ph.Owner); err != nil && !sharedWith(cx, viewerID, ph) {
+		return err
+	}
+	err := datastore.Get(cx, ph.Key(cx), &Photo{})
+	if err == datastore.ErrNoSuchEntity {
+		return notFound("No such photo", err)
+	}
+	if err != nil {
+		return serverError("Unable to get photo", err)
+	}
+	return nil
+}
+
+// SetVisibility changes who can see my photos (visibility) : Status
+func SetVisibility(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) error {
+	v := p["visibility"]
+	if v != VisibilityPublic && v != VisibilityFollowers && v != VisibilityApproved {
+		return badRequest("Invalid visibility")
+	}
+	err := datastore.RunInTransaction(cx, func(cx appengine.Context) error {
+		u, err := findUser(cx, at.ID())
+		if err != nil {
+			return err
+		}
+		u.Visibility = v
+		_, err = datastore.Put(cx, datastore.NewKey(cx, "User", at.ID(), 0, nil), u)
+		return err
+	}, nil)
+	if err != nil {
+		return serverError("Unable to set visibility", err)
+	}
+	replyOk(w)
+	return nil
+}
+
+// Approve lets one of my followers see my photos when I'm VisibilityApproved (personid) : Status
+func Approve(cx appengine.Context, at Access, id PersonID, w http.ResponseWriter) error {
+	personID := string(id)
+	err := datastore.RunInTransaction(cx, func(cx appengine.Context) error {
+		u, err := findUser(cx, at.ID())
+		if err != nil {
+			return err
+		}
+		if uniqueP(u.FollowsMe, personID) {
+			return notFound("Not a follower", nil)
+		}
+		if uniqueP(u.Approved, personID) {
+			u.Approved = append(u.Approved, personID)
+			_, err = datastore.Put(cx, datastore.NewKey(cx, "User", at.ID(), 0, nil), u)
+		}
+		return err
+	}, nil)
+	if _, ok := err.(*apiError); ok {
+		return err
+	}
+	if err != nil {
+		return serverError("Unable to approve", err)
+	}
+	delayINowFollow.Call(cx, personID, at.ID()) // catch them up on what they missed
+	replyOk(w)
+	return nil
+}
+
+// Unapprove takes back an approval (personid) : Status
+func Unapprove(cx appengine.Context, at Access, id PersonID, w http.ResponseWriter) error {
+	personID := string(id)
+	err := datastore.RunInTransaction(cx, func(cx appengine.Context) error {
+		u, err := findUser(cx, at.ID())
+		if err != nil {
+			return err
+		}
+		for i, id := range u.Approved {
+			if id == personID {
+				u.Approved = append(u.Approved[:i], u.Approved[i+1:]...)
+				_, err = datastore.Put(cx, datastore.NewKey(cx, "User", at.ID(), 0, nil), u)
+				return err
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return serverError("Unable to unapprove", err)
+	}
+	replyOk(w)
+	return nil
+}
+
+// Block stops someone from seeing me, and from being suggested to me (personid) : Status
+func Block(cx appengine.Context, at Access, id PersonID, w http.ResponseWriter) error {
+	personID := string(id)
+	if personID == at.ID() {
+		return badRequest("You can't block yourself")
+	}
+	err := datastore.RunInTransaction(cx, func(cx appengine.Context) error {
+		u, err := findUser(cx, at.ID())
+		if err != nil {
+			return err
+		}
+		if uniqueP(u.Blocked, personID) {
+			u.Blocked = append(u.Blocked, personID)
+			_, err = datastore.Put(cx, datastore.NewKey(cx, "User", at.ID(), 0, nil), u)
+		}
+		return err
+	}, nil)
+	if err != nil {
+		return serverError("Unable to block", err)
+	}
+	replyOk(w)
+	return nil
+}
+
+// Unblock takes back a block (personid) : Status
+func Unblock(cx appengine.Context, at Access, id PersonID, w http.ResponseWriter) error {
+	personID := string(id)
+	err := datastore.RunInTransaction(cx, func(cx appengine.Context) error {
+		u, err := findUser(cx, at.ID())
+		if err != nil {
+			return err
+		}
+		for i, id := range u.Blocked {
+			if id == personID {
+				u.Blocked = append(u.Blocked[:i], u.Blocked[i+1:]...)
+				_, err = datastore.Put(cx, datastore.NewKey(cx, "User", at.ID(), 0, nil), u)
+				return err
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return serverError("Unable to unblock", err)
+	}
+	replyOk(w)
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/GoogleCloudPlatform/abelana-gcp/third_party/redisx"
+
+	"appengine"
+
+	"github.com/go-martini/martini"
+)
+
+// statusTooManyRequests is RFC 6585's 429, which net/http doesn't know about yet.
+const statusTooManyRequests = 429
+
+// RateLimit is the token bucket for one route.  Each caller may make Burst requests at once, and
+// gets PerMinute more every minute.  A zero PerMinute means no limit.
+type RateLimit struct {
+	UserPerMinute float64
+	UserBurst     int
+	IPPerMinute   float64
+	IPBurst       int
+}
+
+// clock is how the rate limiter tells time, so tests can substitute their own.
+var clock = time.Now
+
+// tokenBuckets takes a token from each of the buckets KEYS[1] and KEYS[2], the user's and the
+// client IP's, refilling them first for the time that has passed since we last looked.  It takes
+// from both or from neither, so a request one limit turns away doesn't use up the other.
+// ARGV is now (ms), then the refill rate (tokens/ms) and burst size of each bucket; a bucket with
+// a rate of 0 has no limit.  It returns 0 if the caller may proceed, otherwise the number of ms
+// until a token is available in both.
+var tokenBuckets = redisx.NewScript(2, `
+local now = tonumber(ARGV[1])
+local wait = 0
+local buckets = {}
+for i = 1, 2 do
+  local rate = tonumber(ARGV[2 * i])
+  local burst = tonumber(ARGV[2 * i + 1])
+  if rate > 0 then
+    local b = redis.call('HMGET', KEYS[i], 't', 'tok')
+    local t = tonumber(b[1])
+    local tok = tonumber(b[2])
+    if t == nil or tok == nil then
+      t = now
+      tok = burst
+    end
+    if now > t then
+      tok = math.min(burst, tok + (now - t) * rate)
+    end
+    if tok < 1 then
+      wait = math.max(wait, math.ceil((1 - tok) / rate))
+    end
+    buckets[#buckets + 1] = {key = KEYS[i], t = math.max(now, t), tok = tok, rate = rate, burst = burst}
+  end
+end
+for _, b in ipairs(buckets) do
+  if wait == 0 then
+    b.tok = b.tok - 1
+  end
+  redis.call('HMSET', b.key, 't', tostring(b.t), 'tok', tostring(b.tok))
+  redis.call('PEXPIRE', b.key, math.ceil(b.burst / b.rate) + 1000)
+end
+return wait
+`)
+
+// rateLimit is martini middleware that limits the route called name, per user and per client IP.
+// It must come after Aauth.  If Redis is unavailable, we let the request through.
+func rateLimit(name string) martini.Handler {
+	return func(cx appengine.Context, at Access, rq *http.Request, w http.ResponseWriter) error {
+		rl, ok := abelanaConfig().RateLimits[name]
+		if !ok {
+			return nil
+		}
+		conn := pool.Get(cx)
+		defer conn.Close()
+
+		ip := clientIP(rq)
+		wait, err := takeTokens(conn, name, at.ID(), ip, rl)
+		if err != nil {
+			cx.Errorf("rateLimit %v user %v ip %v %v", name, at.ID(), ip, err)
+		}
+		if wait > 0 {
+			if DEBUG {
+				cx.Infof("rateLimit: %v %v wait %v", name, at.ID(), wait)
+			}
+			w.Header().Set("Retry-After", retryAfter(wait))
+			return &apiError{Code: statusTooManyRequests, Message: "Too many requests", Retryable: true}
+		}
+		return nil
+	}
+}
+
+// takeTokens returns how long the caller must wait before trying the route called name again,
+// 0 means go ahead.
+func takeTokens(conn redisx.Conn, name, userID, ip string, rl RateLimit) (time.Duration, error) {
+	if rl.UserPerMinute <= 0 && rl.IPPerMinute <= 0 {
+		return 0, nil
+	}
+	args := redisx.Args{"RL:" + name + ":u:" + userID, "RL:" + name + ":ip:" + ip, clock().UnixNano() / int64(time.Millisecond)}
+	for _, b := range []struct {
+		perMinute float64
+		burst     int
+	}{{rl.UserPerMinute, rl.UserBurst}, {rl.IPPerMinute, rl.IPBurst}} {
+		if b.perMinute < 0 {
+			b.perMinute = 0
+		}
+		if b.burst < 1 {
+			b.burst = 1
+		}
+		rate := b.perMinute / float64(time.Minute/time.Millisecond)
+		args = args.Add(strconv.FormatFloat(rate, 'g', -1, 64), b.burst)
+	}
+	ms, err := redisx.Int64(tokenBuckets.Do(conn, args...))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// retryAfter is wait in whole seconds, rounded up, for the Retry-After header.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int((wait + time.Second - 1) / time.Second))
+}
+
+// clientIP finds the caller's address, App Engine gives it to us without a port.
+func clientIP(rq *http.Request) string {
+	if host, _, err := net.SplitHostPort(rq.RemoteAddr); err == nil {
+		return host
+	}
+	return rq.RemoteAddr
+}
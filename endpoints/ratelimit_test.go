@@ -0,0 +1,246 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/abelana-gcp/third_party/lua"
+	"github.com/GoogleCloudPlatform/abelana-gcp/third_party/redisx"
+
+	"appengine/socket"
+)
+
+// fakeRedis runs scripts with a Lua interpreter, over hashes kept in memory.  It knows only the
+// commands tokenBuckets uses.
+type fakeRedis struct {
+	hashes map[string]map[string]string
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{hashes: make(map[string]map[string]string)}
+}
+
+func (r *fakeRedis) Close() error                      { return nil }
+func (r *fakeRedis) Err() error                        { return nil }
+func (r *fakeRedis) Send(string, ...interface{}) error { return fmt.Errorf("fake redis: no Send") }
+func (r *fakeRedis) Flush() error                      { return nil }
+func (r *fakeRedis) Receive() (interface{}, error)     { return nil, fmt.Errorf("fake redis: no Receive") }
+func (r *fakeRedis) SocketConn() *socket.Conn          { return nil }
+func (r *fakeRedis) Do(cmd string, args ...interface{}) (interface{}, error) {
+	switch cmd {
+	case "EVALSHA":
+		return nil, redisx.Error("NOSCRIPT No matching script")
+	case "EVAL":
+		return r.eval(args[0].(string), args[1].(int), args[2:])
+	}
+	return nil, fmt.Errorf("fake redis: no %v", cmd)
+}
+
+func (r *fakeRedis) eval(src string, nkeys int, args []interface{}) (interface{}, error) {
+	L := lua.NewState()
+	defer L.Close()
+
+	keys, argv := L.NewTable(), L.NewTable()
+	for i, a := range args {
+		if i < nkeys {
+			keys.Append(lua.LString(fmt.Sprint(a)))
+		} else {
+			argv.Append(lua.LString(fmt.Sprint(a)))
+		}
+	}
+	L.SetGlobal("KEYS", keys)
+	L.SetGlobal("ARGV", argv)
+	redis := L.NewTable()
+	L.SetField(redis, "call", L.NewFunction(r.call))
+	L.SetGlobal("redis", redis)
+
+	if err := L.DoString(src); err != nil {
+		return nil, err
+	}
+	switch v := L.Get(-1).(type) {
+	case lua.LNumber:
+		return int64(v), nil // Redis makes numbers integers
+	case lua.LString:
+		return []byte(v), nil
+	}
+	return nil, nil
+}
+
+func (r *fakeRedis) call(L *lua.LState) int {
+	key := L.CheckString(2)
+	h := r.hashes[key]
+	switch cmd := strings.ToUpper(L.CheckString(1)); cmd {
+	case "HMGET":
+		vs := L.NewTable()
+		for i := 3; i <= L.GetTop(); i++ {
+			if v, ok := h[L.CheckString(i)]; ok {
+				vs.Append(lua.LString(v))
+			} else {
+				vs.Append(lua.LFalse)
+			}
+		}
+		L.Push(vs)
+	case "HMSET":
+		if h == nil {
+			h = make(map[string]string)
+			r.hashes[key] = h
+		}
+		for i := 3; i < L.GetTop(); i += 2 {
+			h[L.CheckString(i)] = L.CheckString(i + 1)
+		}
+		L.Push(lua.LString("OK"))
+	case "PEXPIRE":
+		L.Push(lua.LNumber(1))
+	default:
+		L.RaiseError("fake redis: no %v", cmd)
+	}
+	return 1
+}
+
+// withClock stops the rate limiter's clock at now, and returns a func to move it on.
+func withClock(now time.Time) (advance func(time.Duration), restore func()) {
+	old := clock
+	clock = func() time.Time { return now }
+	return func(d time.Duration) { now = now.Add(d) }, func() { clock = old }
+}
+
+// take asks for a token, failing the test if Redis does.
+func take(t *testing.T, conn redisx.Conn, userID, ip string, rl RateLimit) time.Duration {
+	wait, err := takeTokens(conn, "like", userID, ip, rl)
+	if err != nil {
+		t.Fatalf("takeTokens: %v", err)
+	}
+	return wait
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	advance, restore := withClock(time.Unix(1420070400, 0))
+	defer restore()
+	conn := newFakeRedis()
+	rl := RateLimit{UserPerMinute: 6, UserBurst: 3}
+
+	for i := 0; i < 3; i++ {
+		if wait := take(t, conn, "u1", "10.0.0.1", rl); wait != 0 {
+			t.Fatalf("request %d of the burst waits %v", i+1, wait)
+		}
+	}
+	if wait := take(t, conn, "u1", "10.0.0.1", rl); wait == 0 {
+		t.Fatalf("request past the burst went ahead")
+	}
+	if wait := take(t, conn, "u2", "10.0.0.1", rl); wait != 0 {
+		t.Fatalf("another user waits %v", wait)
+	}
+
+	// However long it's been, the bucket holds no more than a burst.
+	advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if wait := take(t, conn, "u1", "10.0.0.1", rl); wait != 0 {
+			t.Fatalf("request %d of the burst after an hour waits %v", i+1, wait)
+		}
+	}
+	if wait := take(t, conn, "u1", "10.0.0.1", rl); wait == 0 {
+		t.Fatalf("request past the burst after an hour went ahead")
+	}
+}
+
+func TestRateLimitRefill(t *testing.T) {
+	advance, restore := withClock(time.Unix(1420070400, 0))
+	defer restore()
+	conn := newFakeRedis()
+	rl := RateLimit{UserPerMinute: 60, UserBurst: 1}
+
+	if wait := take(t, conn, "u1", "10.0.0.1", rl); wait != 0 {
+		t.Fatalf("first request waits %v", wait)
+	}
+	if wait := take(t, conn, "u1", "10.0.0.1", rl); wait != time.Second {
+		t.Fatalf("second request waits %v, want 1s", wait)
+	}
+	advance(400 * time.Millisecond)
+	if wait := take(t, conn, "u1", "10.0.0.1", rl); wait != 600*time.Millisecond {
+		t.Fatalf("after 400ms waits %v, want 600ms", wait)
+	}
+	advance(600 * time.Millisecond)
+	if wait := take(t, conn, "u1", "10.0.0.1", rl); wait != 0 {
+		t.Fatalf("after refilling waits %v", wait)
+	}
+}
+
+func TestRateLimitIP(t *testing.T) {
+	_, restore := withClock(time.Unix(1420070400, 0))
+	defer restore()
+	conn := newFakeRedis()
+	rl := RateLimit{UserPerMinute: 60, UserBurst: 3, IPPerMinute: 60, IPBurst: 1}
+
+	if wait := take(t, conn, "u1", "10.0.0.1", rl); wait != 0 {
+		t.Fatalf("first request waits %v", wait)
+	}
+	for i := 0; i < 5; i++ {
+		if wait := take(t, conn, "u1", "10.0.0.1", rl); wait == 0 {
+			t.Fatalf("request %d from a spent IP went ahead", i+2)
+		}
+	}
+	// The IP turned those away, so they didn't cost the user anything.
+	for _, ip := range []string{"10.0.0.2", "10.0.0.3"} {
+		if wait := take(t, conn, "u1", ip, rl); wait != 0 {
+			t.Fatalf("request from %v waits %v", ip, wait)
+		}
+	}
+	if wait := take(t, conn, "u1", "10.0.0.4", rl); wait == 0 {
+		t.Fatalf("request past the user's burst went ahead")
+	}
+}
+
+func TestRateLimitNone(t *testing.T) {
+	conn := newFakeRedis()
+	for i := 0; i < 100; i++ {
+		if wait := take(t, conn, "u1", "10.0.0.1", RateLimit{}); wait != 0 {
+			t.Fatalf("unlimited route waits %v", wait)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	advance, restore := withClock(time.Unix(1420070400, 0))
+	defer restore()
+	conn := newFakeRedis()
+	rl := RateLimit{UserPerMinute: 6, UserBurst: 1}
+
+	take(t, conn, "u1", "10.0.0.1", rl)
+	if got := retryAfter(take(t, conn, "u1", "10.0.0.1", rl)); got != "10" {
+		t.Errorf("Retry-After is %v, want 10", got)
+	}
+	advance(8500 * time.Millisecond)
+	if got := retryAfter(take(t, conn, "u1", "10.0.0.1", rl)); got != "2" {
+		t.Errorf("Retry-After 1.5s later is %v, want 2", got)
+	}
+
+	for _, c := range []struct {
+		wait time.Duration
+		want string
+	}{
+		{time.Millisecond, "1"},
+		{time.Second, "1"},
+		{1001 * time.Millisecond, "2"},
+		{time.Minute, "60"},
+	} {
+		if got := retryAfter(c.wait); got != c.want {
+			t.Errorf("retryAfter(%v) = %v, want %v", c.wait, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/abelana-gcp/third_party/redisx"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/delay"
+)
+
+// A reshare puts someone else's photo at the top of my followers' timelines, and RS:ffffff
+// remembers who they got it from, for as long as the photo is in their timeline.  Like every
+// photo, it's moved rather than added, see timelinePush.
+
+var delayReshare = delay.Func("reshare", reshare)
+
+// Reshare sends a photo I can see to my followers (photoid) : Status
+func Reshare(cx appengine.Context, at Access, ph PhotoID, w http.ResponseWriter) error {
+	if ph.Owner == at.ID() {
+		return badRequest("You can't reshare your own photo")
+	}
+	if err := checkPhoto(cx, at.ID(), ph); err != nil {
+		return err
+	}
+
+	k := datastore.NewKey(cx, "Reshare", at.ID(), 0, ph.Key(cx))
+	if _, err := datastore.Put(cx, k, &ToLike{at.ID()}); err != nil {
+		return serverError("Unable to reshare", err)
+	}
+	delayReshare.Call(cx, at.ID(), ph.ID)
+
+	if ph.Owner != systemUserID {
+		publish(cx, Event{Type: EventReshare, UserID: at.ID(), PhotoID: ph.ID, Time: time.Now().UTC().Unix()}, ph.Owner)
+		if err := recordActivity(cx, ph.Owner, Activity{Type: EventReshare, UserID: at.ID(), PhotoID: ph.ID}); err != nil {
+			cx.Errorf("Reshare: recordActivity %v", err)
+		}
+	}
+	replyOk(w)
+	return nil
+}
+
+// reshare moves photoID to the top of the timeline of each of userID's followers who may see it.
+// This is allways called from a Delay.
+func reshare(cx appengine.Context, userID, photoID string) error {
+	ph, err := parsePhotoID(photoID)
+	if err != nil {
+		return nil // don't retry this.
+	}
+	u, err := findUser(cx, userID)
+	if err != nil {
+		return fmt.Errorf("reshare: findUser %v %v", userID, err)
+	}
+	var owner *User
+	if ph.Owner != systemUserID {
+		if owner, err = findUser(cx, ph.Owner); err != nil {
+			return fmt.Errorf("reshare: findUser %v %v", ph.Owner, err)
+		}
+	}
+
+	var list []string
+	for _, f := range audience(u) {
+		if f != ph.Owner && (owner == nil || canSee(owner, f)) {
+			list = append(list, f)
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+
+	conn := pool.Get(cx)
+	defer conn.Close()
+
+	if err := timelinePush.Load(conn); err != nil {
+		return fmt.Errorf("reshare: %v", err)
+	}
+	for _, f := range list {
+		sendTimelinePush(conn, f, photoID, userID)
+	}
+	if _, err := conn.Do(""); err != nil {
+		cx.Errorf("reshare: %v %v", photoID, err)
+	}
+	publish(cx, Event{Type: EventReshare, UserID: userID, PhotoID: photoID, Time: time.Now().UTC().Unix()}, list...)
+	return nil
+}
+
+// addResharers says who reshared each entry in userID's timeline, if anyone did.
+func addResharers(cx appengine.Context, conn redisx.Conn, userID string, tl []TLEntry) {
+	if len(tl) == 0 {
+		return
+	}
+	args := redisx.Args{"RS:" + userID}
+	for _, te := range tl {
+		args = args.Add(te.PhotoID)
+	}
+	by, err := redisx.Strings(conn.Do("HMGET", args...))
+	if err != nil && err != redisx.ErrNil {
+		cx.Errorf("addResharers: %v %v", userID, err)
+		return
+	}
+	for i, id := range by {
+		if id == "" || i >= len(tl) {
+			continue
+		}
+		tl[i].ResharedBy = id
+		tl[i].ResharerName, err = redisx.String(conn.Do("HGET", "HT:"+id, "dn"))
+		if err != nil && err != redisx.ErrNil {
+			cx.Errorf("addResharers: HGET %v %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"net/http"
+
+	"appengine"
+
+	"github.com/go-martini/martini"
+)
+
+// route is one endpoint.  The same table registers it with martini and describes it in the
+// OpenAPI document, so the two can't disagree.
+type route struct {
+	Method   string
+	Path     string
+	Name     string      // the operationId clients will see
+	Request  interface{} // JSON body, if any
+	Response interface{}
+	Produces string // the response's content type, if it isn't JSON
+	Handlers []martini.Handler
+}
+
+func rt(method, path, name string, req, resp interface{}, h ...martini.Handler) route {
+	return route{Method: method, Path: path, Name: name, Request: req, Response: resp, Handlers: h}
+}
+
+// stream is r, which sends a text/event-stream of its Response rather than one JSON object.
+func stream(r route) route {
+	r.Produces = "text/event-stream"
+	return r
+}
+
+// apiVersions are the versions of the API we serve, each under /<version>/.  To change the API
+// without breaking older clients, add a "v2" with its own routes here and keep v1 as it is.
+var apiVersions = map[string][]route{
+	"v1": v1Routes,
+}
+
+// legacyVersion is also served without a prefix, for clients that predate /v1/.
+const legacyVersion = "v1"
+
+var v1Routes = []route{
+	rt("POST", "/user/login", "login", LoginRequest{}, ATOKJson{}, Login),
+	rt("GET", "/user/:atok/refresh", "refresh", nil, ATOKJson{}, Aauth, Refresh),
+	rt("POST", "/user/:atok/upload/:format", "getUploadUrl", nil, UploadURL{}, Aauth, GetUploadURL),
+	rt("DELETE", "/user/:atok", "wipeout", nil, Status{}, Aauth, Wipeout),
+	rt("POST", "/user/:atok/following/facebook/:fbkey", "importFacebook", nil, Status{}, Aauth, Import),
+	rt("POST", "/user/:atok/following/plus/:plkey", "importPlus", nil, Status{}, Aauth, Import),
+	rt("POST", "/user/:atok/following/yahoo/:ykey", "importYahoo", nil, Status{}, Aauth, Import),
+	rt("GET", "/user/:atok/following", "getFollowing", nil, Persons{}, Aauth, GetFollowing),
+	rt("PUT", "/user/:atok/following/:personid", "followById", nil, Status{}, Aauth, withPerson, rateLimit("follow"), FollowByID),
+	rt("GET", "/user/:atok/following/:personid", "getPerson", nil, Person{}, Aauth, withPerson, GetPerson),
+	rt("PUT", "/user/:atok/follow/:email", "follow", nil, Status{}, Aauth, rateLimit("follow"), Follow),
+	rt("PUT", "/user/:atok/device/:regid", "register", nil, Status{}, Aauth, Register),
+	rt("GET", "/user/:atok/stats", "statistics", nil, Stats{}, Aauth, Statistics),
+	rt("DELETE", "/user/:atok/device/:regid", "unregister", nil, Status{}, Aauth, Unregister),
+	rt("GET", "/user/:atok/timeline/:lastid", "getTimeline", nil, Timeline{}, Aauth, GetTimeLine),
+	rt("GET", "/user/:atok/inbox/:lastid", "getInbox", nil, Timeline{}, Aauth, GetInbox),
+	rt("GET", "/user/:atok/profile/:lastdate", "getMyProfile", nil, Timeline{}, Aauth, withLastDate, GetMyProfile),
+	rt("GET", "/user/:atok/following/:personid/profile/:lastdate", "getProfile", nil, Timeline{}, Aauth, withPerson, withLastDate, FProfile),
+	stream(rt("GET", "/user/:atok/events", "events", nil, Event{}, Aauth, Events)),
+	stream(rt("GET", "/events/:atok", "streamEvents", nil, Event{}, Aauth, Events)), // dispatched to the events module
+	rt("GET", "/user/:atok/activity/:before", "getActivity", nil, Activities{}, Aauth, withBefore, GetActivity),
+	rt("PUT", "/user/:atok/activity/read", "readActivity", nil, Status{}, Aauth, ReadActivity),
+	rt("POST", "/user/:atok/album", "createAlbum", AlbumRequest{}, Album{}, Aauth, CreateAlbum),
+	rt("GET", "/user/:atok/album", "getMyAlbums", nil, AlbumList{}, Aauth, GetMyAlbums),
+	rt("PUT", "/user/:atok/album/:albumid", "updateAlbum", AlbumRequest{}, Album{}, Aauth, withAlbum, UpdateAlbum),
+	rt("DELETE", "/user/:atok/album/:albumid", "deleteAlbum", nil, Status{}, Aauth, withAlbum, DeleteAlbum),
+	rt("PUT", "/user/:atok/album/:albumid/order", "orderAlbum", AlbumOrder{}, Status{}, Aauth, withAlbum, OrderAlbum),
+	rt("GET", "/user/:atok/album/:albumid/timeline/:lastid", "getMyAlbum", nil, Timeline{}, Aauth, withAlbum, GetMyAlbum),
+	rt("PUT", "/user/:atok/album/:albumid/photo/:photoid", "addToAlbum", nil, Status{}, Aauth, withAlbum, withPhoto, AddToAlbum),
+	rt("DELETE", "/user/:atok/album/:albumid/photo/:photoid", "removeFromAlbum", nil, Status{}, Aauth, withAlbum, withPhoto, RemoveFromAlbum),
+	rt("GET", "/user/:atok/following/:personid/album", "getAlbums", nil, AlbumList{}, Aauth, withPerson, GetAlbums),
+	rt("GET", "/user/:atok/following/:personid/album/:albumid/timeline/:lastid", "getAlbum", nil, Timeline{}, Aauth, withPerson, withAlbum, GetAlbum),
+	rt("GET", "/user/:atok/suggestions", "getSuggestions", nil, Persons{}, Aauth, GetSuggestions),
+	rt("PUT", "/user/:atok/blocked/:personid", "block", nil, Status{}, Aauth, withPerson, Block),
+	rt("DELETE", "/user/:atok/blocked/:personid", "unblock", nil, Status{}, Aauth, withPerson, Unblock),
+	rt("PUT", "/user/:atok/visibility/:visibility", "setVisibility", nil, Status{}, Aauth, SetVisibility),
+	rt("PUT", "/user/:atok/followers/:personid/approved", "approve", nil, Status{}, Aauth, withPerson, Approve),
+	rt("DELETE", "/user/:atok/followers/:personid/approved", "unapprove", nil, Status{}, Aauth, withPerson, Unapprove),
+	rt("GET", "/explore/:atok", "explore", nil, Timeline{}, Aauth, GetExplore),
+
+	rt("POST", "/photo/:atok/:photoid/comment/:text", "setComment", nil, Status{}, Aauth, withPhoto, rateLimit("comment"), SetPhotoComments),
+	rt("GET", "/photo/:atok/:photoid/comments", "getComments", nil, Comments{}, Aauth, withPhoto, GetPhotoComments),
+	rt("PUT", "/photo/:atok/:photoid/like", "like", nil, Status{}, Aauth, withPhoto, rateLimit("like"), Like),
+	rt("DELETE", "/photo/:atok/:photoid/like", "unlike", nil, Status{}, Aauth, withPhoto, rateLimit("like"), Unlike),
+	rt("PUT", "/photo/:atok/:photoid/reshare", "reshare", nil, Status{}, Aauth, withPhoto, rateLimit("reshare"), Reshare),
+	rt("POST", "/photo/:atok/:photoid/share", "share", ShareRequest{}, Status{}, Aauth, withPhoto, rateLimit("share"), SharePhoto),
+	rt("GET", "/photo/:atok/:photoid/image/:rendition", "getImage", nil, Image{}, Aauth, withPhoto, GetImage),
+	rt("GET", "/photo/:atok/:photoid/flag", "flag", nil, Status{}, Aauth, withPhoto, rateLimit("flag"), Flag),
+}
+
+func init() {
+	m := martini.Classic()
+	m.Map(martini.ReturnHandler(returnHandler))
+	m.Use(func(c martini.Context, r *http.Request) {
+		c.MapTo(appengine.NewContext(r), (*appengine.Context)(nil))
+	})
+
+	for v, routes := range apiVersions {
+		m.Group("/"+v, register(v, routes))
+	}
+	m.Group("", register(legacyVersion, apiVersions[legacyVersion]))
+
+	m.Post("/photopush/:superid", PostPhoto) // => Status
+	m.Get("/admin/suggest", SuggestAll)      // cron => Status
+
+	http.Handle("/", m)
+}
+
+// register adds the routes for a version, and its OpenAPI document.
+func register(version string, routes []route) func(martini.Router) {
+	return func(r martini.Router) {
+		for _, e := range routes {
+			r.AddRoute(e.Method, e.Path, e.Handlers...)
+		}
+		r.Get("/openapi.json", openAPIHandler(version, routes))
+	}
+}
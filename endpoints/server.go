@@ -25,11 +25,13 @@ import (
 	"appengine"
 	"appengine/datastore"
 	"appengine/delay"
+	"appengine/taskqueue"
 	"appengine/urlfetch"
 	"appengine/user"
 
 	auth "code.google.com/p/google-api-go-client/oauth2/v2"
 
+	"github.com/GoogleCloudPlatform/abelana-gcp/third_party/redisx"
 	"github.com/go-martini/martini"
 )
 
@@ -37,27 +39,49 @@ import (
 // IM:uuuuuu.ppppppp HASH an imageID
 //   date  is the date the photo was added
 //   flag  DON'T SHOW THIS TO OTHERS 'TIL REVIEW -- must get +2
+//   w, h  the width and height of the original, if the resizer told us
 //   uuuuuu is the id of a user that likes the photo
-//   (Total count of likes is (HLEN k) -2)
+//   (Total count of likes is (HLEN k) less the fields above that are set)
 //
-// TL:uuuuuu LIST The timeline[max 2000] for each user. (list of photos)
+// TL:uuuuuu LIST The timeline[max 2000] for each user, each photo once. (list of photos)
 // HT:uuuuuu HASH
 //   dn is the displayName for the user.
+// EN:uuuuuu the id of the last Event for the user
+// EV:uuuuuu LIST the most recent Events[max 100] for the user, newest first
+// CH:uuuuuu pub/sub channel for the user's Events
+// IN:uuuuuu LIST The inbox[max 2000] of photos shared with just the user, newest first
+// AC:uuuuuu LIST the most recent Activity[max 200] for the user, newest first
+// AU:uuuuuu the number of unread Activity for the user
+// RS:uuuuuu HASH who reshared each photo in TL: to the user
+//   ppppppp is the photoID, the value is the userID of the resharer
+// SG:uuuuuu ZSET people the user might follow, scored by suggestFor
+// EX ZSET the most popular public photos[max 2000], see exploreWeight
+// EL:uuuuuu.ppppppp HASH what each like of a photo in EX was worth
+// RL:route:u:uuuuuu / RL:route:ip:a.b.c.d HASH a rate limiting token bucket
+//   t   is when we last took a token (ms)
+//   tok is the number of tokens left
 
 // In datastore we have the following:
 // User >> Photo >> Like
 //               >> Comments
+//               >> Reshare
+//               >> Share
+//      >> Activity
+//      >> Album
+// PendingShare
 
 var DEBUG = true
 
 var (
 	delayCopyUserPhoto = delay.Func("copyUserPhoto", copyUserPhoto)
-	delayAddPhoto      = delay.Func("addPhoto", addPhoto)
+	delayAddPhoto      = delay.Func("addPhoto", addPhoto) // only for tasks already queued
+	delayAddPhotoInfo  = delay.Func("addPhotoInfo", addPhotoInfo)
 	delayINowFollow    = delay.Func("iNowFollow", iNowFollow)
 	delayFindFollows   = delay.Func("findFollows", findFollows)
 	delayInitialPhotos = delay.Func("initialPhotos", initialPhotos)
 	delayFollowById    = delay.Func("followById", followById)
-	delayInitialSetup  = delay.Func("initialSetup", initialSetup)
+	delayInitialSetup  = delay.Func("initialSetup", initialSetup) // only for tasks already queued
+	delaySetupUser     = delay.Func("setupUser", setupUser)
 )
 
 type (
@@ -69,12 +93,30 @@ type (
 		FollowsMe     []string // list of userID's
 		IFollow       []string
 		IWantToFollow []string // list of email addresses
+		Visibility    string   // who can see my photos, see canSee()
+		Approved      []string // followers who may see my photos when I'm VisibilityApproved
+		Blocked       []string // people who may never see me, or be suggested to me
 	}
 
 	// Photo is how we keep images in Datastore
 	Photo struct {
 		PhotoID string
 		Date    int64
+		Width   int // of the original, 0 if we don't know
+		Height  int
+
+		// When and where it was taken, if the resizer was asked to tell us.  Never shown to others.
+		Taken       int64   `datastore:",noindex"`
+		Lat, Lng    float64 `datastore:",noindex"`
+		HasLocation bool    `datastore:",noindex"`
+	}
+
+	// PhotoInfo is what the resizer tells us about a new photo.
+	PhotoInfo struct {
+		Width, Height int
+		Taken         int64
+		Lat, Lng      float64
+		HasLocation   bool
 	}
 
 	// ToLike knows about who likes you.
@@ -82,6 +124,13 @@ type (
 		UserID string
 	}
 
+	// LoginRequest is what the client POSTs to log in.
+	LoginRequest struct {
+		Token       string `json:"token"`
+		DisplayName string `json:"displayName"`
+		PhotoURL    string `json:"photoUrl"`
+	}
+
 	// ATOKJson is the json message for an Access Token (TEMPORARY - Until GitKit supports this)
 	ATOKJson struct {
 		Kind string `json:"kind"`
@@ -94,6 +143,25 @@ type (
 		Status string `json:"status"`
 	}
 
+	// UploadURL is where, and how, the client should upload a photo.
+	UploadURL struct {
+		Kind        string            `json:"kind"`
+		PhotoID     string            `json:"photoid"`
+		URL         string            `json:"url"`
+		Method      string            `json:"method"`
+		ContentType string            `json:"contentType"`
+		MaxBytes    int64             `json:"maxBytes"`
+		Expires     int64             `json:"expires"`
+		Headers     map[string]string `json:"headers"` // must be sent with the upload
+	}
+
+	// Image is where to get a rendition of a photo, in a format the client can show.
+	Image struct {
+		Kind        string `json:"kind"`
+		URL         string `json:"url"`
+		ContentType string `json:"contentType"`
+	}
+
 	// TLEntry holds timeline entries
 	TLEntry struct {
 		Created int64  `json:"created"`
@@ -102,6 +170,15 @@ type (
 		PhotoID string `json:"photoid"`
 		Likes   int    `json:"likes"`
 		ILike   bool   `json:"ilike"`
+
+		Width  int     `json:"width,omitempty"` // of the original, so the client can lay it out
+		Height int     `json:"height,omitempty"`
+		Aspect float64 `json:"aspect,omitempty"` // width / height
+
+		ResharedBy   string `json:"resharedBy,omitempty"` // the userid we got it from
+		ResharerName string `json:"resharerName,omitempty"`
+
+		Image *Image `json:"image,omitempty"` // the ?rendition= the client asked for
 	}
 
 	// Timeline the data the client sees.
@@ -137,6 +214,81 @@ type (
 		Entries []Comment `json:"entries"`
 	}
 
+	// Error is what we return when things go wrong.
+	Error struct {
+		Kind      string `json:"kind"`
+		Code      int    `json:"code"`
+		Message   string `json:"message"`
+		Retryable bool   `json:"retryable"`
+	}
+
+	// Event is something that happened which a user may want to know about right away.
+	Event struct {
+		ID      int64  `json:"id"`
+		Type    string `json:"type"`   // EventPhoto, EventLike, ...
+		UserID  string `json:"userid"` // who did it
+		PhotoID string `json:"photoid,omitempty"`
+		Reason  string `json:"reason,omitempty"` // why, for EventRejected
+		Time    int64  `json:"time"`
+	}
+
+	// Activity is something someone did to me, it's kept in Datastore as well.
+	Activity struct {
+		Type    string `json:"type"`   // EventLike, EventComment, EventFollow, EventReshare, EventShare
+		UserID  string `json:"userid"` // who did it
+		PhotoID string `json:"photoid,omitempty"`
+		Time    int64  `json:"time"` // UnixNano, which is what we page by, see Before
+	}
+
+	// Activities is a page of my activity log
+	Activities struct {
+		Kind    string     `json:"kind"`
+		Unread  int        `json:"unread"`
+		Entries []Activity `json:"entries"`
+	}
+
+	// Album is a named, ordered, collection of the owner's photos.
+	Album struct {
+		Kind       string   `json:"kind" datastore:"-"`
+		AlbumID    string   `json:"albumid"`
+		Name       string   `json:"name"`
+		Visibility string   `json:"visibility"` // as well as the owner's, see canSeeAlbum()
+		Cover      string   `json:"cover"`      // a photoid, the first photo if not set
+		Photos     []string `json:"photos,omitempty"`
+		Created    int64    `json:"created"`
+	}
+
+	// AlbumList is the albums someone can see
+	AlbumList struct {
+		Kind   string  `json:"kind"`
+		Albums []Album `json:"albums"`
+	}
+
+	// AlbumRequest creates or changes an album, empty fields are left alone.
+	AlbumRequest struct {
+		Name       string `json:"name"`
+		Visibility string `json:"visibility"`
+		Cover      string `json:"cover"`
+	}
+
+	// AlbumOrder is every photo in the album, in the order they should be.
+	AlbumOrder struct {
+		Photos []string `json:"photos"`
+	}
+
+	// ShareRequest is who to share a photo with, emails we don't know yet get it when they sign up.
+	ShareRequest struct {
+		PersonIDs []string `json:"personids"`
+		Emails    []string `json:"emails"`
+	}
+
+	// PendingShare is a photo shared with an email that hasn't signed up yet.
+	PendingShare struct {
+		Email   string
+		PhotoID string
+		Date    int64
+	}
+
 	// Stats contains useful user statistics
 	Stats struct {
 		Following int `json:"following"`
@@ -144,45 +296,6 @@ type (
 	}
 )
 
-func init() {
-	m := martini.Classic()
-	m.Use(func(c martini.Context, r *http.Request) {
-		c.MapTo(appengine.NewContext(r), (*appengine.Context)(nil))
-	})
-
-	m.Get("/user/:gittok/login/:displayName/:photoUrl", Login)                  // => ATOKJson
-	m.Get("/user/:atok/refresh", Aauth, Refresh)                                // => ATOKJson
-	m.Get("/user/:atok/useful", Aauth, GetSecretKey)                            // => Status
-	m.Delete("/user/:atok", Aauth, Wipeout)                                     // => Status
-	m.Post("/user/:atok/following/facebook/:fbkey", Aauth, Import)              // => Status
-	m.Post("/user/:atok/following/plus/:plkey", Aauth, Import)                  // => Status
-	m.Post("/user/:atok/following/yahoo/:ykey", Aauth, Import)                  // => Status
-	m.Get("/user/:atok/following", Aauth, GetFollowing)                         // => Persons
-	m.Put("/user/:atok/following/:personid", Aauth, FollowByID)                 // => Status
-	m.Get("/user/:atok/following/:personid", Aauth, GetPerson)                  // => Person
-	m.Put("/user/:atok/follow/:email", Aauth, Follow)                           // => Status
-	m.Put("/user/:atok/device/:regid", Aauth, Register)                         // => Status
-	m.Get("/user/:atok/stats", Aauth, Statistics)                               // => Stats
-	m.Delete("/user/:atok/device/:regid", Aauth, Unregister)                    // => Status
-	m.Get("/user/:atok/timeline/:lastid", Aauth, GetTimeLine)                   // => Timeline
-	m.Get("/user/:atok/profile/:lastdate", Aauth, GetMyProfile)                 // => Timeline
-	m.Get("/user/:atok/following/:personid/profile/:lastdate", Aauth, FProfile) // => Timeline
-
-	m.Post("/photo/:atok/:photoid/comment/:text", Aauth, SetPhotoComments) // => Status
-	m.Get("/photo/:atok/:photoid/comments", Aauth, GetPhotoComments)       // => Comments
-	m.Put("/photo/:atok/:photoid/like", Aauth, Like)                       // => Status
-	m.Delete("/photo/:atok/:photoid/like", Aauth, Unlike)                  // => Status
-	m.Get("/photo/:atok/:photoid/flag", Aauth, Flag)                       // => Status
-
-	m.Post("/photopush/:superid", PostPhoto) // "ok"
-
-	if abelanaConfig().EnableBackdoor {
-		m.Get("/user/:gittok/login", Login)
-	}
-
-	http.Handle("/", m)
-}
-
 // replyJSON Given an object, convert to JSON and reply with it
 func replyJSON(w http.ResponseWriter, v interface{}) {
 	b, err := json.Marshal(v)
@@ -208,59 +321,63 @@ func replyOk(w http.ResponseWriter) {
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 
 // GetTimeLine - get the timeline for the user (token) : TlResp
-func GetTimeLine(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
+func GetTimeLine(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter, rq *http.Request) error {
+	if p["lastid"] != "0" {
+		if _, err := parsePhotoID(p["lastid"]); err != nil {
+			return err
+		}
+	}
 	tl, err := getTimeline(cx, at.ID(), p["lastid"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return serverError("Unable to get timeline", err)
 	}
-	replyJSON(w, Timeline{"abelana#timeline", tl})
+	return replyTimeline(w, rq, tl)
 }
 
 // GetMyProfile - Get my entries only (token) : TlResp
-func GetMyProfile(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
-	tl, err := profileForUser(cx, at.ID(), p["lastdate"])
+func GetMyProfile(cx appengine.Context, at Access, ld LastDate, w http.ResponseWriter, rq *http.Request) error {
+	tl, err := profileForUser(cx, at.ID(), int64(ld))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
-	replyJSON(w, Timeline{"abelana#timeline", tl})
+	return replyTimeline(w, rq, tl)
 }
 
 // FProfile - Get a specific followers entries only (TlfReq) : TlResp
-func FProfile(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
-	tl, err := profileForUser(cx, p["personid"], p["lastdate"])
+func FProfile(cx appengine.Context, at Access, id PersonID, ld LastDate, w http.ResponseWriter, rq *http.Request) error {
+	if _, err := checkVisible(cx, at.ID(), string(id)); err != nil {
+		return err
+	}
+	tl, err := profileForUser(cx, string(id), int64(ld))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
-	replyJSON(w, Timeline{"abelana#timeline", tl})
+	return replyTimeline(w, rq, tl)
 }
 
 // profileForUser will get the 300 most recent photos from the user, we don't provide any info
 // on likes as that would require many trips to the datastore making the call really slow.
-func profileForUser(cx appengine.Context, userID, lastDate string) ([]TLEntry, error) {
+func profileForUser(cx appengine.Context, userID string, lastDate int64) ([]TLEntry, error) {
 	var u User
 	k := datastore.NewKey(cx, "User", userID, 0, nil)
 	err := datastore.Get(cx, k, &u)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, notFound("No such user", err)
+	}
 	if err != nil {
-		cx.Errorf("profileForUser Get1 %v %v", userID, err)
+		return nil, serverError("Unable to get user", err)
 	}
 
 	q := datastore.NewQuery("Photo").Ancestor(k)
-	if lastDate != "" && lastDate != "0" {
-		lastDate, err := strconv.ParseInt(lastDate, 10, 64)
-		if err != nil {
-			cx.Errorf("profileForUser ParseInt %v %v %v", userID, lastDate, err)
-		}
-		q = q.Filter("", lastDate)
+	if lastDate != 0 {
+		q = q.Filter("Date <", lastDate)
 	}
 	// TimelineBatchSize guides our paging mechanism.
 	q = q.Order("-Date").Limit(abelanaConfig().TimelineBatchSize)
 	var photos []Photo
 	_, err = q.GetAll(cx, &photos)
 	if err != nil {
-		cx.Errorf("profileForUser get2 %v %v", userID, err)
+		return nil, serverError("Unable to get photos", err)
 	}
 
 	var tl []TLEntry
@@ -271,7 +388,10 @@ func profileForUser(cx appengine.Context, userID, lastDate string) ([]TLEntry, e
 			Name:    u.DisplayName,
 			PhotoID: p.PhotoID,
 			Likes:   -1, // TODO: don't return the likes in the profile for users
-			ILike:   false},
+			ILike:   false,
+			Width:   p.Width,
+			Height:  p.Height,
+			Aspect:  aspect(p.Width, p.Height)},
 		)
 	}
 	if DEBUG {
@@ -285,8 +405,9 @@ func profileForUser(cx appengine.Context, userID, lastDate string) ([]TLEntry, e
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 
 // Import for Facebook / G+ / ... (xcred) : StatusResp
-func Import(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
+func Import(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) error {
 	replyOk(w)
+	return nil
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////
@@ -294,19 +415,14 @@ func Import(cx appengine.Context, at Access, p martini.Params, w http.ResponseWr
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 
 // GetFollowing - A list of those I follow (AToken) :
-func GetFollowing(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
-	var u User
-	err := datastore.Get(cx, datastore.NewKey(cx, "User", at.ID(), 0, nil), &u)
+func GetFollowing(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) error {
+	u, err := findUser(cx, at.ID())
 	if err != nil {
-		cx.Errorf("GetFollowing %v %v", at.ID(), err)
-		replyOk(w)
-		return
+		return serverError("Unable to get user", err)
 	}
 	ps, err := getPersons(cx, u.IFollow)
 	if err != nil {
-		cx.Errorf("GetFollowing %v %v", at.ID(), err)
-		replyOk(w)
-		return
+		return serverError("Unable to get persons", err)
 	}
 	replyJSON(w, Persons{
 		Kind:    "abelana#followerList",
@@ -315,58 +431,53 @@ func GetFollowing(cx appengine.Context, at Access, p martini.Params, w http.Resp
 	if DEBUG {
 		cx.Infof("GetFollowing: %v", ps)
 	}
+	return nil
 }
 
 // GetPerson -- find out about someone  : Person
-func GetPerson(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
-	var u User
-	err := datastore.Get(cx, datastore.NewKey(cx, "User", p["personid"], 0, nil), &u)
+func GetPerson(cx appengine.Context, at Access, id PersonID, w http.ResponseWriter) error {
+	u, err := checkVisible(cx, at.ID(), string(id))
 	if err != nil {
-		cx.Errorf("GetPerson %v %v", p["personid"], err)
-		replyOk(w)
-		return
+		return err
 	}
 	replyJSON(w, &Person{"abelana#follower", u.UserID, u.Email, u.DisplayName})
 	if DEBUG {
 		cx.Infof("GetPerson: %v %v %v", u.UserID, u.Email, u.DisplayName)
 	}
+	return nil
 }
 
 // FollowByID - will tell us about a new possible follower (FrReq) : Status
-func FollowByID(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
-	if err := followById(cx, at.ID(), p["personid"]); err != nil {
-		cx.Errorf("FollowByID: %v", err)
+func FollowByID(cx appengine.Context, at Access, id PersonID, w http.ResponseWriter) error {
+	if _, err := findUser(cx, string(id)); err == datastore.ErrNoSuchEntity {
+		return notFound("No such person", err)
+	}
+	if err := followById(cx, at.ID(), string(id)); err != nil {
+		return serverError("Unable to follow", err)
 	}
 	replyOk(w)
+	return nil
 }
 
 // Follow will see if we can follow the user, given their email
-func Follow(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
-	var users []User
-	var keys []*datastore.Key
+func Follow(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) error {
 	eMail, err := decodeSegment(p["email"])
 	if err != nil {
-		cx.Errorf("Follow: ds %v %v", p["email"], err)
-		replyOk(w)
-		return
+		return badRequest("Invalid email")
 	}
 	email := string(eMail)
-	// TODO try looking them up in GitKit as it has many versions of email addresses.
 
-	q := datastore.NewQuery("User").Filter("Email =", email).KeysOnly()
-	keys, err = q.GetAll(cx, &users)
+	id, err := findUserIDByEmail(cx, email)
 	if err != nil {
-		cx.Errorf("Follow: %v %v", email, err)
-		replyOk(w)
-		return
+		return serverError("Unable to look up email", err)
 	}
-	if len(keys) > 0 {
+	if id != "" {
 		if DEBUG {
-			cx.Infof("Follow - Found: (%v) %v %v", len(keys), email, keys[0].StringID())
+			cx.Infof("Follow - Found: %v %v", email, id)
 		}
-		err = followById(cx, at.ID(), keys[0].StringID())
+		err = followById(cx, at.ID(), id)
 		if err != nil {
-			cx.Errorf("Follow: followByID: %v", err)
+			return serverError("Unable to follow", err)
 		}
 	} else {
 		if DEBUG {
@@ -385,10 +496,22 @@ func Follow(cx appengine.Context, at Access, p martini.Params, w http.ResponseWr
 			return nil
 		}, nil)
 		if err != nil {
-			cx.Errorf("Follow: %v %v", eMail, err)
+			return serverError("Unable to follow", err)
 		}
 	}
 	replyOk(w)
+	return nil
+}
+
+// findUserIDByEmail finds the user with this email, "" if there isn't one.
+func findUserIDByEmail(cx appengine.Context, email string) (string, error) {
+	// TODO try looking them up in GitKit as it has many versions of email addresses.
+	q := datastore.NewQuery("User").Filter("Email =", email).KeysOnly().Limit(1)
+	keys, err := q.GetAll(cx, nil)
+	if err != nil || len(keys) == 0 {
+		return "", err
+	}
+	return keys[0].StringID(), nil
 }
 
 // findFollows will do the major explosion for the social network, it is called by Delay and it will
@@ -410,6 +533,7 @@ func findFollows(cx appengine.Context, userID, email string) error {
 
 // followById makes following a user easy once we know who they are
 func followById(cx appengine.Context, userID, followingID string) error {
+	var added bool
 	to := &datastore.TransactionOptions{XG: true}
 	err := datastore.RunInTransaction(cx, func(cx appengine.Context) error {
 		user := &User{}
@@ -437,7 +561,8 @@ func followById(cx appengine.Context, userID, followingID string) error {
 			}
 		}
 
-		if uniqueP(followed.FollowsMe, userID) {
+		added = uniqueP(followed.FollowsMe, userID)
+		if added {
 			followed.FollowsMe = append(followed.FollowsMe, userID)
 			_, err = datastore.Put(cx, kFollowed, followed)
 			if err != nil {
@@ -451,6 +576,11 @@ func followById(cx appengine.Context, userID, followingID string) error {
 		return err
 	}
 	delayINowFollow.Call(cx, userID, followingID)
+	if added {
+		if err := recordActivity(cx, followingID, Activity{Type: EventFollow, UserID: userID}); err != nil {
+			cx.Errorf("followById: recordActivity %v", err)
+		}
+	}
 	return nil
 }
 
@@ -465,14 +595,14 @@ func uniqueP(list []string, item string) bool {
 }
 
 // Statistics will tell you about a user
-func Statistics(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
+func Statistics(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) error {
 	u, err := findUser(cx, at.ID())
 	if err != nil {
-		cx.Errorf("Statistics %v", err)
-		replyJSON(w, &Stats{-1, -1})
+		return serverError("Unable to get user", err)
 	}
 	st := &Stats{len(u.IFollow), len(u.FollowsMe)}
 	replyJSON(w, st)
+	return nil
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////
@@ -480,123 +610,196 @@ func Statistics(cx appengine.Context, at Access, p martini.Params, w http.Respon
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 
 // SetPhotoComments allows the users voice to be heard (PhotoComment) : Status
-func SetPhotoComments(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
-	s := strings.Split(p["photoid"], ".")
-	if len(s) != 2 {
-		replyOk(w)
-		return
+func SetPhotoComments(cx appengine.Context, at Access, ph PhotoID, p martini.Params, w http.ResponseWriter) error {
+	if err := checkPhoto(cx, at.ID(), ph); err != nil {
+		return err
 	}
-	userID, photoID := s[0], p["photoid"]
 
 	tod := time.Now().UTC().Unix()
-	k1 := datastore.NewKey(cx, "User", userID, 0, nil)
-	k2 := datastore.NewKey(cx, "Photo", photoID, 0, k1)
-	k3 := datastore.NewKey(cx, "Comment", "", tod, k2)
+	k := datastore.NewKey(cx, "Comment", "", tod, ph.Key(cx))
 	c := &Comment{at.ID(), p["text"], tod}
-	_, err := datastore.Put(cx, k3, c)
+	_, err := datastore.Put(cx, k, c)
 	if err != nil {
-		cx.Errorf("SetPhotoComments: %v %v", k3, err)
+		return serverError("Unable to save comment", err)
+	}
+	if ph.Owner != at.ID() {
+		publish(cx, Event{Type: EventComment, UserID: at.ID(), PhotoID: ph.ID, Time: tod}, ph.Owner)
+		if err := recordActivity(cx, ph.Owner, Activity{Type: EventComment, UserID: at.ID(), PhotoID: ph.ID}); err != nil {
+			cx.Errorf("SetPhotoComments: recordActivity %v", err)
+		}
 	}
 	replyOk(w)
+	return nil
 }
 
 // GetPhotoComments will get the comments given a photoid
-func GetPhotoComments(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
+func GetPhotoComments(cx appengine.Context, at Access, ph PhotoID, w http.ResponseWriter) error {
 	var c []Comment
 
-	s := strings.Split(p["photoid"], ".")
-	if len(s) != 2 {
-		replyOk(w)
-		return
+	if err := checkPhoto(cx, at.ID(), ph); err != nil {
+		return err
 	}
-	userID, photoID := s[0], p["photoid"]
-	k1 := datastore.NewKey(cx, "User", userID, 0, nil)
-	k2 := datastore.NewKey(cx, "Photo", photoID, 0, k1)
 
-	q := datastore.NewQuery("Comment").Ancestor(k2).Order("Time")
+	q := datastore.NewQuery("Comment").Ancestor(ph.Key(cx)).Order("Time")
 	_, err := q.GetAll(cx, &c)
 	if err != nil {
-		cx.Errorf("GetPhotoComments %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return serverError("Unable to get comments", err)
 	}
 	cl := &Comments{"abelana#comments", c}
 	replyJSON(w, cl)
+	return nil
 }
 
 // Like let's the user tell of their joy (Photo) : Status
-func Like(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
-	s := strings.Split(p["photoid"], ".")
-	if len(s) != 2 {
-		replyOk(w)
-		return
+func Like(cx appengine.Context, at Access, ph PhotoID, w http.ResponseWriter) error {
+	if err := checkPhoto(cx, at.ID(), ph); err != nil {
+		return err
 	}
-	userID, photoID := s[0], p["photoid"]
 
-	like(cx, at.ID(), photoID)
+	if err := like(cx, at.ID(), ph.ID); err != nil {
+		return serverError("Unable to like", err)
+	}
 
-	k1 := datastore.NewKey(cx, "User", userID, 0, nil)
-	k2 := datastore.NewKey(cx, "Photo", photoID, 0, k1)
-	k3 := datastore.NewKey(cx, "Like", at.ID(), 0, k2)
+	k := datastore.NewKey(cx, "Like", at.ID(), 0, ph.Key(cx))
 	l := &ToLike{at.ID()}
-	_, err := datastore.Put(cx, k3, l)
+	_, err := datastore.Put(cx, k, l)
 	if err != nil {
-		cx.Errorf("Like: %v %v", k3, err)
+		return serverError("Unable to like", err)
+	}
+	if ph.Owner != at.ID() {
+		publish(cx, Event{Type: EventLike, UserID: at.ID(), PhotoID: ph.ID, Time: time.Now().UTC().Unix()}, ph.Owner)
+		if err := recordActivity(cx, ph.Owner, Activity{Type: EventLike, UserID: at.ID(), PhotoID: ph.ID}); err != nil {
+			cx.Errorf("Like: recordActivity %v", err)
+		}
 	}
 	replyOk(w)
+	return nil
 }
 
 // Unlike let's the user recind their +1 (Photo) : Status
-func Unlike(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
-	s := strings.Split(p["photoid"], ".")
-	if len(s) != 2 {
-		replyOk(w)
-		return
-	}
-	userID, photoID := s[0], p["photoid"]
-	k1 := datastore.NewKey(cx, "User", userID, 0, nil)
-	k2 := datastore.NewKey(cx, "Photo", photoID, 0, k1)
-	k3 := datastore.NewKey(cx, "Like", at.ID(), 0, k2)
-	err := datastore.Delete(cx, k3)
+func Unlike(cx appengine.Context, at Access, ph PhotoID, w http.ResponseWriter) error {
+	k := datastore.NewKey(cx, "Like", at.ID(), 0, ph.Key(cx))
+	err := datastore.Delete(cx, k)
 	if err != nil {
-		cx.Errorf("Unlike: %v %v", k3, err)
-		replyOk(w)
-		return
+		return serverError("Unable to unlike", err)
+	}
+	if err := unlike(cx, at.ID(), ph.ID); err != nil {
+		return serverError("Unable to unlike", err)
 	}
-	unlike(cx, at.ID(), photoID)
 	replyOk(w)
+	return nil
 }
 
 // Flag will bring this to the administrators attention.
-func Flag(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
-	s := strings.Split(p["photoid"], ".")
-	if len(s) != 2 {
-		replyOk(w)
-		return
+func Flag(cx appengine.Context, at Access, ph PhotoID, w http.ResponseWriter) error {
+	if err := flag(cx, at.ID(), ph.ID); err != nil {
+		return serverError("Unable to flag", err)
 	}
-	flag(cx, at.ID(), p["photoid"])
 
 	//  We should also write something to Datastore
 
 	replyOk(w)
+	return nil
 }
 
 // PostPhoto lets us know that we have a photo, we then tell both DataStore and Redis
 // What is sent is just the id, either uuuuu.rrrrr or uuuuu where u=userID, and rrrrr is random photoID
-func PostPhoto(cx appengine.Context, p martini.Params, w http.ResponseWriter, rq *http.Request) string {
+// The form may have the width and height of the original, and when and where it was taken, or
+// why the resizer rejected it.  The resizer retries until we answer, so it may tell us more than
+// once; each announcement has an Idempotency-Key, and we only act on the first we see of each.
+func PostPhoto(cx appengine.Context, p martini.Params, w http.ResponseWriter, rq *http.Request) error {
 	otok := rq.Header.Get("Authorization")
 	if !appengine.IsDevAppServer() {
 		ok, err := authorized(cx, otok)
-		if !ok || err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return ``
+		if err != nil {
+			return serverError("Unable to check authorization", err)
+		}
+		if !ok {
+			return unauthorized("Not authorized", nil)
 		}
 	}
 	s := strings.Split(p["superid"], ".")
 	if len(s) == 2 { // We only need to call for userid.photoID.webp
-		delayAddPhoto.Call(cx, p["superid"])
+		key := rq.Header.Get("Idempotency-Key")
+		if key != "" {
+			first, err := firstDelivery(cx, key)
+			if err != nil {
+				return serverError("Unable to check idempotency key", err)
+			}
+			if !first {
+				cx.Infof("PostPhoto: duplicate %v %v", p["superid"], key)
+				replyOk(w)
+				return nil
+			}
+		}
+		if reason := rq.FormValue("rejected"); reason != "" {
+			rejectPhoto(cx, p["superid"], reason)
+		} else if err := queueAddPhoto(cx, p["superid"], photoInfo(rq)); err != nil {
+			// The resizer will try again, so this mustn't look like a duplicate when it does.
+			if key != "" {
+				forgetDelivery(cx, key)
+			}
+			return serverError("Unable to queue photo", err)
+		}
 	}
-	return `ok`
+	replyOk(w)
+	return nil
+}
+
+// queueAddPhoto adds the photo in a task, unlike delayAddPhotoInfo.Call it says if it couldn't.
+func queueAddPhoto(cx appengine.Context, photoID string, info PhotoInfo) error {
+	t, err := delayAddPhotoInfo.Task(photoID, info)
+	if err != nil {
+		return err
+	}
+	_, err = taskqueue.Add(cx, t, "")
+	return err
+}
+
+// Idempotency keys, which the resizer sends with each photo
+const (
+	maxIdempotencyKey = 256
+	idempotencyTTL    = 24 * time.Hour
+)
+
+// firstDelivery is true the first time we see key, PK:key is kept for a day, long after the
+// resizer has given up.
+func firstDelivery(cx appengine.Context, key string) (bool, error) {
+	if len(key) > maxIdempotencyKey {
+		return false, fmt.Errorf("idempotency key too long")
+	}
+	conn := pool.Get(cx)
+	defer conn.Close()
+
+	_, err := redisx.String(conn.Do("SET", "PK:"+key, 1, "EX", int(idempotencyTTL/time.Second), "NX"))
+	if err == redisx.ErrNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// forgetDelivery lets key be delivered again.
+func forgetDelivery(cx appengine.Context, key string) {
+	conn := pool.Get(cx)
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", "PK:"+key); err != nil {
+		cx.Errorf("forgetDelivery: %v %v", key, err)
+	}
+}
+
+// photoInfo reads what the resizer sent about the photo, anything it didn't send is 0.
+func photoInfo(rq *http.Request) PhotoInfo {
+	var pi PhotoInfo
+	pi.Width, _ = strconv.Atoi(rq.FormValue("width"))
+	pi.Height, _ = strconv.Atoi(rq.FormValue("height"))
+	pi.Taken, _ = strconv.ParseInt(rq.FormValue("taken"), 10, 64)
+	lat, err1 := strconv.ParseFloat(rq.FormValue("lat"), 64)
+	lng, err2 := strconv.ParseFloat(rq.FormValue("lng"), 64)
+	if err1 == nil && err2 == nil && lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180 {
+		pi.Lat, pi.Lng, pi.HasLocation = lat, lng, true
+	}
+	return pi
 }
 
 // authorized verifies the auth token.  We could do this ourselves using Admin if our caller had used
@@ -630,17 +833,20 @@ func authorized(cx appengine.Context, token string) (bool, error) {
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 
 // Wipeout will erase all data you are working on. (Atok) : Status
-func Wipeout(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
+func Wipeout(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) error {
 
 	replyOk(w)
+	return nil
 }
 
 // Register will start GCM messages to your device (GCMReq) : Status
-func Register(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
+func Register(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) error {
 	replyOk(w)
+	return nil
 }
 
 // Unregister will stop GCM messages from going to your device (GCMReq) : Status
-func Unregister(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) {
+func Unregister(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) error {
 	replyOk(w)
+	return nil
 }
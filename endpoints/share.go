@@ -0,0 +1,169 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/abelana-gcp/third_party/redisx"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/delay"
+
+	"github.com/go-martini/martini"
+)
+
+// Sharing a photo with someone puts it in their inbox, IN:uuuuuu, rather than their timeline, and
+// lets them see it whoever else may.  Photo >> Share records who it was shared with.  If we don't
+// know the email yet, a PendingShare waits for them to sign up, like IWantToFollow.
+const maxShareRecipients = 50
+
+var (
+	delayDeliverShare = delay.Func("deliverShare", deliverShare)
+	delayFindShares   = delay.Func("findShares", findShares)
+)
+
+// SharePhoto sends one of my photos to some people (ShareRequest) : Status
+func SharePhoto(cx appengine.Context, at Access, ph PhotoID, rq *http.Request, w http.ResponseWriter) error {
+	if ph.Owner != at.ID() {
+		return forbidden("Only your own photos")
+	}
+	var sr ShareRequest
+	if err := json.NewDecoder(io.LimitReader(rq.Body, 64<<10)).Decode(&sr); err != nil {
+		return badRequest("Invalid share")
+	}
+	if len(sr.PersonIDs)+len(sr.Emails) == 0 {
+		return badRequest("Nobody to share with")
+	}
+	if len(sr.PersonIDs)+len(sr.Emails) > maxShareRecipients {
+		return badRequest("Too many people")
+	}
+	if err := checkPhoto(cx, at.ID(), ph); err != nil {
+		return err
+	}
+
+	var ids []string
+	for _, id := range sr.PersonIDs {
+		if _, err := parseUserID(id); err != nil {
+			return err
+		}
+		if _, err := findUser(cx, id); err == datastore.ErrNoSuchEntity {
+			return notFound("No such person", err)
+		} else if err != nil {
+			return serverError("Unable to get person", err)
+		}
+		ids = append(ids, id)
+	}
+	for _, email := range sr.Emails {
+		id, err := findUserIDByEmail(cx, email)
+		if err != nil {
+			return serverError("Unable to look up email", err)
+		}
+		if id != "" {
+			ids = append(ids, id)
+			continue
+		}
+		ps := &PendingShare{email, ph.ID, time.Now().UTC().Unix()}
+		if _, err := datastore.Put(cx, datastore.NewIncompleteKey(cx, "PendingShare", nil), ps); err != nil {
+			return serverError("Unable to share", err)
+		}
+	}
+	sent := map[string]bool{at.ID(): true}
+	for _, id := range ids {
+		if !sent[id] {
+			sent[id] = true
+			delayDeliverShare.Call(cx, ph.ID, id)
+		}
+	}
+	replyOk(w)
+	return nil
+}
+
+// GetInbox is the photos that have been shared with me (lastid) : Timeline
+func GetInbox(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter, rq *http.Request) error {
+	if p["lastid"] != "0" {
+		if _, err := parsePhotoID(p["lastid"]); err != nil {
+			return err
+		}
+	}
+	conn := pool.Get(cx)
+	defer conn.Close()
+
+	list, err := redisx.Strings(conn.Do("LRANGE", "IN:"+at.ID(), 0, -1))
+	if err != nil && err != redisx.ErrNil {
+		return serverError("Unable to get inbox", err)
+	}
+	return replyTimeline(w, rq, timelineEntries(cx, conn, at.ID(), page(list, p["lastid"]), true))
+}
+
+// sharedWith tells us if the photo's owner shared it with viewerID.
+func sharedWith(cx appengine.Context, viewerID string, ph PhotoID) bool {
+	err := datastore.Get(cx, datastore.NewKey(cx, "Share", viewerID, 0, ph.Key(cx)), &ToLike{})
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		cx.Errorf("sharedWith: %v %v %v", viewerID, ph.ID, err)
+	}
+	return err == nil
+}
+
+// deliverShare lets userID see photoID and puts it at the top of their inbox.  This is allways
+// called from a Delay.
+func deliverShare(cx appengine.Context, photoID, userID string) error {
+	ph, err := parsePhotoID(photoID)
+	if err != nil {
+		return nil // don't retry this.
+	}
+	k := datastore.NewKey(cx, "Share", userID, 0, ph.Key(cx))
+	if _, err := datastore.Put(cx, k, &ToLike{userID}); err != nil {
+		return fmt.Errorf("deliverShare: put %v %v %v", photoID, userID, err)
+	}
+
+	conn := pool.Get(cx)
+	defer conn.Close()
+
+	conn.Send("LREM", "IN:"+userID, 0, photoID)
+	conn.Send("LPUSH", "IN:"+userID, photoID)
+	conn.Send("LTRIM", "IN:"+userID, 0, 1999)
+	if _, err := conn.Do(""); err != nil {
+		return fmt.Errorf("deliverShare: %v %v %v", photoID, userID, err)
+	}
+
+	publish(cx, Event{Type: EventShare, UserID: ph.Owner, PhotoID: photoID, Time: time.Now().UTC().Unix()}, userID)
+	if err := recordActivity(cx, userID, Activity{Type: EventShare, UserID: ph.Owner, PhotoID: photoID}); err != nil {
+		cx.Errorf("deliverShare: recordActivity %v", err)
+	}
+	return nil
+}
+
+// findShares delivers what was shared with email before userID signed up, it is called by Delay.
+func findShares(cx appengine.Context, userID, email string) error {
+	var pending []PendingShare
+	q := datastore.NewQuery("PendingShare").Filter("Email =", email)
+	keys, err := q.GetAll(cx, &pending)
+	if err != nil {
+		return fmt.Errorf("findShares: GetAll %v", err)
+	}
+	for _, ps := range pending {
+		delayDeliverShare.Call(cx, ps.PhotoID, userID)
+	}
+	if err := datastore.DeleteMulti(cx, keys); err != nil {
+		return fmt.Errorf("findShares: DeleteMulti %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/abelana-gcp/third_party/redisx"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/delay"
+	"appengine/user"
+)
+
+// Suggestions are people I might want to follow.  Cron calls SuggestAll once a day, which works
+// through every user a batch at a time, and the results are kept in SG:uuuuuu until the next run.
+const (
+	maxSuggestions   = 50
+	suggestBatchSize = 200
+	suggestExpire    = 3 * 24 * time.Hour // if cron stops, they go away
+	suggestMaxFollow = 100                // how many of those I follow we look at
+	suggestMaxLikes  = 20                 // how many of my likes we look at
+
+	followWeight    = 2 // someone I follow follows them
+	followsMeWeight = 3 // they follow me, but I don't follow them
+	likeWeight      = 1 // they like, or took, a photo I like
+)
+
+var (
+	delaySuggestBatch *delay.Function // set in init, as it calls itself
+	delaySuggestFor   = delay.Func("suggestFor", suggestFor)
+)
+
+func init() {
+	delaySuggestBatch = delay.Func("suggestBatch", suggestBatch)
+}
+
+// GetSuggestions is who I might want to follow, best first : Persons
+func GetSuggestions(cx appengine.Context, at Access, w http.ResponseWriter) error {
+	u, err := findUser(cx, at.ID())
+	if err != nil {
+		return serverError("Unable to get user", err)
+	}
+
+	conn := pool.Get(cx)
+	defer conn.Close()
+	ids, err := redisx.Strings(conn.Do("ZREVRANGE", "SG:"+at.ID(), 0, -1))
+	if err != nil && err != redisx.ErrNil {
+		return serverError("Unable to get suggestions", err)
+	}
+
+	var list []string
+	for _, id := range ids { // things may have changed since we worked them out
+		if uniqueP(u.IFollow, id) && uniqueP(u.Blocked, id) {
+			list = append(list, id)
+		}
+	}
+	ps, err := getPersons(cx, list)
+	if err != nil {
+		return serverError("Unable to get persons", err)
+	}
+	replyJSON(w, Persons{Kind: "abelana#suggestions", Persons: ps})
+	return nil
+}
+
+// SuggestAll starts working out everyone's suggestions, it's called by cron.
+func SuggestAll(cx appengine.Context, rq *http.Request, w http.ResponseWriter) error {
+	if rq.Header.Get("X-Appengine-Cron") != "true" && !user.IsAdmin(cx) {
+		return forbidden("Not allowed")
+	}
+	delaySuggestBatch.Call(cx, "")
+	replyOk(w)
+	return nil
+}
+
+// suggestBatch queues suggestFor for a batch of users and then the next batch, it's called by
+// Delay.
+func suggestBatch(cx appengine.Context, cursor string) error {
+	q := datastore.NewQuery("User").KeysOnly()
+	if cursor != "" {
+		c, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return nil // don't retry this.
+		}
+		q = q.Start(c)
+	}
+	t := q.Run(cx)
+	for i := 0; i < suggestBatchSize; i++ {
+		k, err := t.Next(nil)
+		if err == datastore.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("suggestBatch: %v", err)
+		}
+		delaySuggestFor.Call(cx, k.StringID())
+	}
+	c, err := t.Cursor()
+	if err != nil {
+		return fmt.Errorf("suggestBatch: cursor %v", err)
+	}
+	delaySuggestBatch.Call(cx, c.String())
+	return nil
+}
+
+// suggestFor ranks the people userID might follow.  Being followed by people they follow counts,
+// as does following them, or liking the same photos.
+func suggestFor(cx appengine.Context, userID string) error {
+	u, err := findUser(cx, userID)
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("suggestFor: findUser %v %v", userID, err)
+	}
+	score := make(map[string]int)
+
+	following := u.IFollow
+	if len(following) > suggestMaxFollow {
+		following = following[len(following)-suggestMaxFollow:] // the most recent
+	}
+	var keys []*datastore.Key
+	for _, id := range following {
+		keys = append(keys, datastore.NewKey(cx, "User", id, 0, nil))
+	}
+	fs := make([]User, len(keys))
+	if err := datastore.GetMulti(cx, keys, fs); err != nil {
+		if _, ok := err.(appengine.MultiError); !ok {
+			return fmt.Errorf("suggestFor: GetMulti %v %v", userID, err)
+		}
+	}
+	for _, f := range fs {
+		for _, id := range f.IFollow {
+			score[id] += followWeight
+		}
+	}
+	for _, id := range u.FollowsMe {
+		score[id] += followsMeWeight
+	}
+
+	q := datastore.NewQuery("Like").Filter("UserID =", userID).KeysOnly().Limit(suggestMaxLikes)
+	likes, err := q.GetAll(cx, nil)
+	if err != nil {
+		return fmt.Errorf("suggestFor: likes %v %v", userID, err)
+	}
+	for _, l := range likes {
+		ph := l.Parent()
+		score[ph.Parent().StringID()] += likeWeight
+		others, err := datastore.NewQuery("Like").Ancestor(ph).KeysOnly().Limit(suggestMaxFollow).GetAll(cx, nil)
+		if err != nil {
+			cx.Errorf("suggestFor: likes of %v %v", ph.StringID(), err)
+			continue
+		}
+		for _, o := range others {
+			score[o.StringID()] += likeWeight
+		}
+	}
+
+	delete(score, userID)
+	delete(score, systemUserID)
+	for _, id := range u.IFollow {
+		delete(score, id)
+	}
+	for _, id := range u.Blocked {
+		delete(score, id)
+	}
+	ranked := make(byScore, 0, len(score))
+	for id, s := range score {
+		ranked = append(ranked, suggestion{id, s})
+	}
+	sort.Sort(ranked)
+
+	// Leave out anyone who has blocked us, or can't be found.
+	var best []suggestion
+	for len(ranked) > 0 && len(best) < maxSuggestions {
+		n := maxSuggestions - len(best)
+		if n > len(ranked) {
+			n = len(ranked)
+		}
+		batch := ranked[:n]
+		ranked = ranked[n:]
+		keys = keys[:0]
+		for _, s := range batch {
+			keys = append(keys, datastore.NewKey(cx, "User", s.id, 0, nil))
+		}
+		us := make([]User, len(keys))
+		err := datastore.GetMulti(cx, keys, us)
+		me, _ := err.(appengine.MultiError)
+		if err != nil && me == nil {
+			return fmt.Errorf("suggestFor: GetMulti %v %v", userID, err)
+		}
+		for i, s := range batch {
+			if (me == nil || me[i] == nil) && uniqueP(us[i].Blocked, userID) {
+				best = append(best, s)
+			}
+		}
+	}
+
+	conn := pool.Get(cx)
+	defer conn.Close()
+
+	conn.Send("DEL", "SG:"+userID)
+	if len(best) > 0 {
+		args := redisx.Args{"SG:" + userID}
+		for _, s := range best {
+			args = args.Add(s.score, s.id)
+		}
+		conn.Send("ZADD", args...)
+		conn.Send("EXPIRE", "SG:"+userID, int(suggestExpire/time.Second))
+	}
+	if _, err := conn.Do(""); err != nil {
+		return fmt.Errorf("suggestFor: %v %v", userID, err)
+	}
+	return nil
+}
+
+type suggestion struct {
+	id    string
+	score int
+}
+
+// byScore puts the best suggestions first.
+type byScore []suggestion
+
+func (s byScore) Len() int      { return len(s) }
+func (s byScore) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byScore) Less(i, j int) bool {
+	if s[i].score != s[j].score {
+		return s[i].score > s[j].score
+	}
+	return s[i].id < s[j].id
+}
@@ -24,12 +24,15 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"math/big"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"appengine"
@@ -38,12 +41,21 @@ import (
 	"github.com/google/identity-toolkit-go-client/gitkit"
 )
 
+// The GitKit client, our signing key and how we check identities are read from private/ the
+// first time they're needed, so the package loads without them, as in tests.
 var (
-	gclient *gitkit.Client
-	signKey *ecdsa.PrivateKey
+	gclient    *gitkit.Client
+	signKey    *ecdsa.PrivateKey
+	identities IdentityProvider
+	keysOnce   sync.Once
 )
 
-func init() {
+// loadKeys makes sure gclient, signKey and identities are set.
+func loadKeys() {
+	keysOnce.Do(readKeys)
+}
+
+func readKeys() {
 	var config *gitkit.Config
 	var err error
 	// Provide configuration. gitkit.LoadConfig() can also be used to load
@@ -60,6 +72,7 @@ func init() {
 	if err != nil {
 		log.Fatalf("new gitkit.New ** %v", err)
 	}
+	identities = newIdentityProvider(abelanaConfig(), gclient)
 	key, err := ioutil.ReadFile("private/signing-key.pem")
 	if err != nil {
 		log.Fatalf("Unable to get signing Key %v", err)
@@ -71,119 +84,125 @@ func init() {
 	}
 }
 
-// Login - see if the token is valid
-func Login(cx appengine.Context, p martini.Params, w http.ResponseWriter) {
-	var token *gitkit.Token
-	var err error
-	var dName, photoURL string
+// maxLoginBody is more than enough for a GitKit token and a couple of strings.
+const maxLoginBody = 64 << 10
 
-	client, err := gitkit.NewWithContext(cx, gclient)
-	if err != nil {
-		cx.Errorf("Failed to create a gitkit.Client with a context: %s", err)
-		http.Error(w, "Initialization failure", http.StatusInternalServerError)
-		return
+// defaultPhotoHosts are where we accept profile photos from if AbelanaConfig.PhotoHosts is empty.
+var defaultPhotoHosts = []string{"googleusercontent.com"}
+
+// Login - see if the token is valid (LoginRequest) : ATOKJson
+func Login(cx appengine.Context, rq *http.Request, w http.ResponseWriter) error {
+	var lr LoginRequest
+	if err := json.NewDecoder(io.LimitReader(rq.Body, maxLoginBody)).Decode(&lr); err != nil {
+		return badRequest("Invalid login request")
 	}
-	dn, err := decodeSegment(p["displayName"])
-	if err != nil {
-		dName = "Name Unavailable"
-	} else {
-		dName = string(dn)
+	if lr.Token == "" {
+		return badRequest("Missing token")
 	}
-	pu, err := decodeSegment(p["photoUrl"])
-	if err != nil {
-		photoURL = ""
-	} else {
-		photoURL = string(pu)
-	}
-	if abelanaConfig().EnableBackdoor && p["gittok"] == "Les" {
-		err = nil
-		token = &gitkit.Token{"Magic", "**AUDIENCE**", time.Now().UTC(),
-			time.Now().UTC().Add(1 * time.Hour), "00001", "lesv@abelana-app.com",
-			true, "abelana-app.com", "LES001"}
-		dName = "Les Vogel"
-		photoURL = "https://lh4.googleusercontent.com/-Nt9PfYHmQeI/AAAAAAAAAAI/AAAAAAAAANI/2mbohwDXFKI/photo.jpg?sz=50"
-	} else {
-		token, err = client.ValidateToken(p["gittok"])
-		if err != nil {
-			cx.Errorf("git.ValidateToken: %v")
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
+	if lr.PhotoURL == "null" {
+		lr.PhotoURL = ""
+	}
+	if lr.PhotoURL != "" {
+		if err := checkPhotoURL(lr.PhotoURL); err != nil {
+			cx.Infof("Login: photoUrl %v", err)
+			return badRequest("Invalid photoUrl")
 		}
 	}
+	dName := lr.DisplayName
+	if dName == "" {
+		dName = "Name Unavailable"
+	}
 
-	at := &AccToken{token.LocalID, time.Now().UTC().Unix(), time.Now().UTC().Add(120 * 24 * time.Hour).Unix()}
-
-	parts := make([]string, 3)
-
-	parts[0] = base64.URLEncoding.EncodeToString([]byte(`{"kid": "abelana"}`))
-	ts, err := json.Marshal(at)
+	loadKeys()
+	id, err := identities.Validate(cx, lr.Token)
 	if err != nil {
-		http.Error(w, "Invalid Token", http.StatusUnauthorized)
-		return
+		if _, ok := err.(TokenError); ok {
+			return unauthorized("Invalid Token", err)
+		}
+		return serverError("Unable to validate token", err)
+	}
+	if id.DisplayName != "" {
+		dName = id.DisplayName
+	}
+	photoURL := lr.PhotoURL
+	if id.PhotoURL != "" {
+		photoURL = id.PhotoURL
 	}
-	parts[1] = base64.URLEncoding.EncodeToString(ts)
 
-	h := md5.New()
-	io.WriteString(h, parts[0]+"."+parts[1])
-	r, s, err := ecdsa.Sign(rand.Reader, signKey, h.Sum(nil))
+	at := &AccToken{id.UserID, time.Now().UTC().Unix(), time.Now().UTC().Add(120 * 24 * time.Hour).Unix()}
+	tok, err := signToken(at)
 	if err != nil {
-		cx.Errorf("ecdsa.Sign %v", err)
-		http.Error(w, "Invalid Token", http.StatusUnauthorized)
-		return
+		return serverError("Unable to create access token", err)
 	}
-	sig := base64.URLEncoding.EncodeToString(r.Bytes()) + "." + base64.URLEncoding.EncodeToString(s.Bytes())
-	parts[2] = base64.URLEncoding.EncodeToString([]byte(sig))
-
-	replyJSON(w, &ATOKJson{"abelana#accessToken", strings.Join(parts, ".")})
+	replyJSON(w, &ATOKJson{"abelana#accessToken", tok})
 
 	// Look us up in datastore and be happy.
 	_, err = findUser(cx, at.UserID)
 	if err != nil {
 		// Not found, must create
-		createUser(cx, User{UserID: at.UserID, DisplayName: dName, Email: token.Email})
-		if photoURL != "" && photoURL != "null" {
+		createUser(cx, User{UserID: at.UserID, DisplayName: dName, Email: id.Email}, id.EmailVerified)
+		if photoURL != "" {
 			delayCopyUserPhoto.Call(cx, photoURL, at.UserID)
 		}
 	}
+	return nil
+}
+
+// checkPhotoURL makes sure we only fetch profile photos over https from hosts we trust.
+func checkPhotoURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("%q is not https", s)
+	}
+	hosts := abelanaConfig().PhotoHosts
+	if len(hosts) == 0 {
+		hosts = defaultPhotoHosts
+	}
+	host := strings.ToLower(u.Host)
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not an allowed host", u.Host)
 }
 
 // Refresh will refresh an Access Token (ATok)
-func Refresh(cx appengine.Context, p martini.Params, w http.ResponseWriter) {
-	//	haveCerts(cx)
-	parts := strings.Split(p["atok"], ".")
-	ct, err := base64.URLEncoding.DecodeString(parts[1])
-	at := &AccToken{}
-	if err = json.Unmarshal(ct, &at); err != nil {
-		http.Error(w, "Invalid Token", http.StatusUnauthorized)
-		return
+func Refresh(cx appengine.Context, at Access, w http.ResponseWriter) error {
+	nt := &AccToken{at.ID(), time.Now().UTC().Unix(), time.Now().UTC().Add(120 * 24 * time.Hour).Unix()}
+	tok, err := signToken(nt)
+	if err != nil {
+		return serverError("Unable to create access token", err)
 	}
-	at.Exp = time.Now().UTC().Add(120 * 24 * time.Hour).Unix()
+	replyJSON(w, &ATOKJson{"abelana#accessToken", tok})
+	return nil
+}
 
+// signToken turns an AccToken into the string we hand to our clients.
+func signToken(at *AccToken) (string, error) {
+	parts := make([]string, 3)
+
+	parts[0] = base64.URLEncoding.EncodeToString([]byte(`{"kid": "abelana"}`))
 	ts, err := json.Marshal(at)
 	if err != nil {
-		http.Error(w, "Invalid Token", http.StatusUnauthorized)
-		return
+		return "", err
 	}
 	parts[1] = base64.URLEncoding.EncodeToString(ts)
 
 	h := md5.New()
 	io.WriteString(h, parts[0]+"."+parts[1])
+	loadKeys()
 	r, s, err := ecdsa.Sign(rand.Reader, signKey, h.Sum(nil))
 	if err != nil {
-		cx.Errorf("ecdsa.Sign %v", err)
-		http.Error(w, "Invalid Token", http.StatusUnauthorized)
-		return
+		return "", fmt.Errorf("ecdsa.Sign %v", err)
 	}
 	sig := base64.URLEncoding.EncodeToString(r.Bytes()) + "." + base64.URLEncoding.EncodeToString(s.Bytes())
 	parts[2] = base64.URLEncoding.EncodeToString([]byte(sig))
 
-	replyJSON(w, &ATOKJson{"abelana#accessToken", strings.Join(parts, ".")})
-}
-
-// GetSecretKey will send our key in a way that we should only be called once.
-func GetSecretKey(w http.ResponseWriter) {
-	st := &Status{"abelana#status", base64.URLEncoding.EncodeToString([]byte(abelanaConfig().ServerKey))}
-	replyJSON(w, st)
+	return strings.Join(parts, "."), nil
 }
 
 /**
@@ -217,80 +236,66 @@ func (at *AccToken) ID() string {
 }
 
 // Aauth validates a given AccessToken
-func Aauth(c martini.Context, cx appengine.Context, p martini.Params, w http.ResponseWriter) {
-	var at *AccToken
-
-	if abelanaConfig().EnableBackdoor && strings.HasPrefix(p["atok"], "LES") {
-		at = &AccToken{"00001", time.Now().UTC().Unix(),
-			time.Now().UTC().Add(120 * 24 * time.Hour).Unix()}
-	} else {
-		part := strings.Split(p["atok"], ".")
-		if len(part) != 3 {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
-		}
-		h, err := base64.URLEncoding.DecodeString(part[0])
-		if err != nil {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
-		}
-		hh := struct {
-			KeyID string `json:"kid"`
-		}{}
-		if err = json.Unmarshal(h, &hh); err != nil {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
-		}
-		if hh.KeyID != "abelana" {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
-		}
+func Aauth(c martini.Context, cx appengine.Context, p martini.Params) error {
+	part := strings.Split(p["atok"], ".")
+	if len(part) != 3 {
+		return unauthorized("Invalid Token", nil)
+	}
+	h, err := base64.URLEncoding.DecodeString(part[0])
+	if err != nil {
+		return unauthorized("Invalid Token", err)
+	}
+	hh := struct {
+		KeyID string `json:"kid"`
+	}{}
+	if err = json.Unmarshal(h, &hh); err != nil {
+		return unauthorized("Invalid Token", err)
+	}
+	if hh.KeyID != "abelana" {
+		return unauthorized("Invalid Token", nil)
+	}
 
-		ct, err := base64.URLEncoding.DecodeString(part[1])
-		if err != nil {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
-		}
-		at = &AccToken{}
-		if err = json.Unmarshal(ct, &at); err != nil {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
-		}
-		if at.UserID == "" || at.Iat == 0 || at.Exp == 0 {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
-		}
-		// Check the signature.
-		sig, err := base64.URLEncoding.DecodeString(part[2])
-		if err != nil {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
-		}
+	ct, err := base64.URLEncoding.DecodeString(part[1])
+	if err != nil {
+		return unauthorized("Invalid Token", err)
+	}
+	at := &AccToken{}
+	if err = json.Unmarshal(ct, &at); err != nil {
+		return unauthorized("Invalid Token", err)
+	}
+	if at.UserID == "" || at.Iat == 0 || at.Exp == 0 {
+		return unauthorized("Invalid Token", nil)
+	}
+	// Check the signature.
+	sig, err := base64.URLEncoding.DecodeString(part[2])
+	if err != nil {
+		return unauthorized("Invalid Token", err)
+	}
 
-		hash := md5.New()
-		io.WriteString(hash, part[0]+"."+part[1])
-		p := strings.Split(string(sig), ".")
-		rp, err := base64.URLEncoding.DecodeString(p[0])
-		if err != nil {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
-		}
-		sp, err := base64.URLEncoding.DecodeString(p[1])
-		if err != nil {
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
-		}
-		r := big.NewInt(0)
-		s := big.NewInt(0)
-		verify := ecdsa.Verify(&signKey.PublicKey, hash.Sum(nil), r.SetBytes(rp), s.SetBytes(sp))
-		if !verify {
-			cx.Errorf("CheckSignature %v %v", at.UserID, err)
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
-			return
-		}
+	hash := md5.New()
+	io.WriteString(hash, part[0]+"."+part[1])
+	rs := strings.Split(string(sig), ".")
+	if len(rs) != 2 {
+		return unauthorized("Invalid Token", nil)
+	}
+	rp, err := base64.URLEncoding.DecodeString(rs[0])
+	if err != nil {
+		return unauthorized("Invalid Token", err)
+	}
+	sp, err := base64.URLEncoding.DecodeString(rs[1])
+	if err != nil {
+		return unauthorized("Invalid Token", err)
+	}
+	r := big.NewInt(0)
+	s := big.NewInt(0)
+	loadKeys()
+	verify := ecdsa.Verify(&signKey.PublicKey, hash.Sum(nil), r.SetBytes(rp), s.SetBytes(sp))
+	if !verify {
+		return unauthorized("Invalid Token", fmt.Errorf("CheckSignature %v", at.UserID))
 	}
 
 	c.MapTo(at, (*Access)(nil))
+	return nil
 }
 
 // decodeSegment decodes the Base64 encoding segment of the JWT token.
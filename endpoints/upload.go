@@ -0,0 +1,120 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package abelana
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"appengine"
+
+	"github.com/go-martini/martini"
+)
+
+// Upload limits, used when AbelanaConfig doesn't say otherwise.
+const (
+	defaultMaxUploadBytes  = 10 << 20
+	defaultUploadURLExpiry = 15 * time.Minute
+)
+
+// uploadTypes are the formats a client may upload, and the Content-Type they must use.
+var uploadTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// GetUploadURL hands the client a signed URL it can PUT exactly one photo to.  The object name is
+// userid.random, so nobody can write over another user's photo. (format) : UploadURL
+func GetUploadURL(cx appengine.Context, at Access, p martini.Params, w http.ResponseWriter) error {
+	ct, ok := uploadTypes[p["format"]]
+	if !ok {
+		return badRequest("Unsupported format")
+	}
+	rnd, err := randomID()
+	if err != nil {
+		return serverError("Unable to create photoid", err)
+	}
+	photoID := at.ID() + "." + rnd
+
+	maxBytes := abelanaConfig().MaxUploadBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxUploadBytes
+	}
+	expiry := time.Duration(abelanaConfig().UploadURLExpiry) * time.Second
+	if expiry <= 0 {
+		expiry = defaultUploadURLExpiry
+	}
+	expires := time.Now().UTC().Add(expiry).Unix()
+	lengthRange := fmt.Sprintf("0,%d", maxBytes)
+
+	u, err := signedPutURL(cx, abelanaConfig().Bucket, photoID, ct, lengthRange, expires)
+	if err != nil {
+		return serverError("Unable to sign upload URL", err)
+	}
+	replyJSON(w, &UploadURL{
+		Kind:        "abelana#uploadUrl",
+		PhotoID:     photoID,
+		URL:         u,
+		Method:      "PUT",
+		ContentType: ct,
+		MaxBytes:    maxBytes,
+		Expires:     expires,
+		Headers:     map[string]string{"x-goog-content-length-range": lengthRange},
+	})
+	return nil
+}
+
+// signedPutURL creates a V2 signed URL for a single object, signed by our service account.  The
+// content type and length range are part of the signature, so the client has to send both.
+// See https://cloud.google.com/storage/docs/access-control#Signed-URLs
+func signedPutURL(cx appengine.Context, bucket, name, contentType, lengthRange string, expires int64) (string, error) {
+	resource := "/" + bucket + "/" + name
+	toSign := "PUT\n" +
+		"\n" + // Content-MD5
+		contentType + "\n" +
+		strconv.FormatInt(expires, 10) + "\n" +
+		"x-goog-content-length-range:" + lengthRange + "\n" +
+		resource
+
+	_, sig, err := appengine.SignBytes(cx, []byte(toSign))
+	if err != nil {
+		return "", fmt.Errorf("SignBytes %v", err)
+	}
+	account, err := appengine.ServiceAccount(cx)
+	if err != nil {
+		return "", fmt.Errorf("ServiceAccount %v", err)
+	}
+
+	q := url.Values{}
+	q.Set("GoogleAccessId", account)
+	q.Set("Expires", strconv.FormatInt(expires, 10))
+	q.Set("Signature", base64.StdEncoding.EncodeToString(sig))
+	return "https://storage.googleapis.com" + resource + "?" + q.Encode(), nil
+}
+
+// randomID makes the random part of a photoID, it never contains a '.'
+func randomID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
@@ -39,8 +39,9 @@ func findUser(cx appengine.Context, id string) (*User, error) {
 	return u, err
 }
 
-// createUser will create the initial datastore entry for the user
-func createUser(cx appengine.Context, user User) error {
+// createUser will create the initial datastore entry for the user, emailVerified is whether the
+// identity provider vouched for their email
+func createUser(cx appengine.Context, user User, emailVerified bool) error {
 	cx.Infof("CreateUser: %v", user)
 	user.IFollow = abelanaConfig().AutoFollowers
 	_, err := datastore.Put(cx, datastore.NewKey(cx, "User", user.UserID, 0, nil), &user)
@@ -49,20 +50,30 @@ func createUser(cx appengine.Context, user User) error {
 		return err
 	}
 	addUser(cx, user.UserID, user.DisplayName) // Tell Redis
-	delayInitialSetup.Call(cx, user.UserID, user.Email)
+	delaySetupUser.Call(cx, user.UserID, user.Email, emailVerified)
 	return nil
 }
 
-// initialSetup will add the initial things in a somewhat reasonable way.
+// initialSetup is what tasks queued before setupUser call, we don't know if their email was
+// verified so nothing shared with it is delivered.
 func initialSetup(cx appengine.Context, ID, email string) error {
+	return setupUser(cx, ID, email, false)
+}
+
+// setupUser will add the initial things in a somewhat reasonable way.  Photos shared with an
+// email only go to someone who has shown it's theirs.
+func setupUser(cx appengine.Context, ID, email string, emailVerified bool) error {
 	for _, key := range abelanaConfig().AutoFollowers {
 		if err := followById(cx, ID, key); err != nil { // follow francesc
-			return fmt.Errorf("initialSetup: %v %v", key, err)
+			return fmt.Errorf("setupUser: %v %v", key, err)
 		}
 	}
 
 	delayInitialPhotos.Call(cx, ID)
 	delayFindFollows.Call(cx, ID, email)
+	if emailVerified {
+		delayFindShares.Call(cx, ID, email)
+	}
 	return nil
 }
 
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+
+	"github.com/gographics/imagick/imagick"
+)
+
+// Where a crop is taken from
+const (
+	cropCenter  = "center"  // the middle of the image, the default
+	cropEntropy = "entropy" // the busiest part, which is usually the subject or a face
+)
+
+// cropSteps is how many slices we take off, at most, when looking for the busiest part.
+const cropSteps = 10
+
+// crop cuts the image down to w x h, which must fit inside it.
+func crop(wand *imagick.MagickWand, w, h uint, gravity string) error {
+	iw, ih := wand.GetImageWidth(), wand.GetImageHeight()
+	x, y := int(iw-w)/2, int(ih-h)/2
+	if gravity == cropEntropy {
+		x = busiest(iw, iw-w, func(at int, n uint) *imagick.MagickWand {
+			return region(wand, n, ih, at, 0)
+		})
+		y = busiest(ih, ih-h, func(at int, n uint) *imagick.MagickWand {
+			return region(wand, iw, n, 0, at)
+		})
+	}
+	if err := wand.CropImage(w, h, x, y); err != nil {
+		return err
+	}
+	return wand.SetImagePage(w, h, 0, 0)
+}
+
+// busiest finds where to start so as to cut excess pixels from a side that is size long.  It
+// takes a slice off whichever end has less going on, until it has taken off enough.
+func busiest(size, excess uint, slice func(at int, n uint) *imagick.MagickWand) int {
+	if excess == 0 {
+		return 0
+	}
+	step := (excess + cropSteps - 1) / cropSteps
+	lo, hi := uint(0), size
+	for hi-lo > size-excess {
+		n := step
+		if left := hi - lo - (size - excess); n > left {
+			n = left
+		}
+		a, b := slice(int(lo), n), slice(int(hi-n), n)
+		if entropy(a) < entropy(b) {
+			lo += n
+		} else {
+			hi -= n
+		}
+		a.Destroy()
+		b.Destroy()
+	}
+	return int(lo)
+}
+
+// region is a copy of part of the image.
+func region(wand *imagick.MagickWand, w, h uint, x, y int) *imagick.MagickWand {
+	r := wand.Clone()
+	r.CropImage(w, h, x, y)
+	return r
+}
+
+// entropy is how much is going on in the image, judged from how its grey levels are spread.
+// Taken as a normal distribution their entropy is log2(sd*sqrt(2*pi*e)), which grows with
+// their standard deviation sd, and busiest only compares two.  The histogram would give it
+// exactly, but GetImageHistogram reads past the end of what ImageMagick returns.
+func entropy(wand *imagick.MagickWand) float64 {
+	if err := wand.TransformImageColorspace(imagick.COLORSPACE_GRAY); err != nil {
+		return math.Inf(-1)
+	}
+	_, sd, err := wand.GetImageChannelMean(imagick.CHANNEL_GRAY)
+	if err != nil || sd <= 0 {
+		return math.Inf(-1)
+	}
+	return math.Log2(sd * math.Sqrt(2*math.Pi*math.E))
+}
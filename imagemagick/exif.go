@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gographics/imagick/imagick"
+)
+
+// exifTime is how EXIF writes dates, which are local to wherever the camera was.
+const exifTime = "2006:01:02 15:04:05"
+
+// autoOrient turns the image the way the camera says it was held, so that phones held
+// sideways don't make sideways photos.
+func autoOrient(wand *imagick.MagickWand) error {
+	var err error
+	switch wand.GetImageOrientation() {
+	case imagick.ORIENTATION_TOP_RIGHT:
+		err = wand.FlopImage()
+	case imagick.ORIENTATION_BOTTOM_RIGHT:
+		err = rotate(wand, 180)
+	case imagick.ORIENTATION_BOTTOM_LEFT:
+		err = wand.FlipImage()
+	case imagick.ORIENTATION_LEFT_TOP:
+		err = wand.TransposeImage()
+	case imagick.ORIENTATION_RIGHT_TOP:
+		err = rotate(wand, 90)
+	case imagick.ORIENTATION_RIGHT_BOTTOM:
+		err = wand.TransverseImage()
+	case imagick.ORIENTATION_LEFT_BOTTOM:
+		err = rotate(wand, 270)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("orient: %v", err)
+	}
+	return wand.SetImageOrientation(imagick.ORIENTATION_TOP_LEFT)
+}
+
+func rotate(wand *imagick.MagickWand, degrees float64) error {
+	bg := imagick.NewPixelWand()
+	defer bg.Destroy()
+	bg.SetColor("none")
+	return wand.RotateImage(bg, degrees)
+}
+
+// captureInfo adds when and where the photo was taken to info, from its EXIF.  It has to be
+// called before the metadata is stripped.
+func captureInfo(wand *imagick.MagickWand, info *photoInfo) {
+	if t, err := time.Parse(exifTime, strings.TrimSpace(wand.GetImageProperty("exif:DateTimeOriginal"))); err == nil {
+		info.Taken = t.Unix()
+	}
+	lat, err := gpsCoordinate(wand.GetImageProperty("exif:GPSLatitude"), wand.GetImageProperty("exif:GPSLatitudeRef"), "S")
+	if err != nil {
+		return
+	}
+	lng, err := gpsCoordinate(wand.GetImageProperty("exif:GPSLongitude"), wand.GetImageProperty("exif:GPSLongitudeRef"), "W")
+	if err != nil {
+		return
+	}
+	info.Lat, info.Lng, info.HasLocation = lat, lng, true
+}
+
+// gpsCoordinate reads an EXIF coordinate, which looks like "37/1, 46/1, 2937/100" for degrees,
+// minutes and seconds.  It is negative if ref is neg.
+func gpsCoordinate(v, ref, neg string) (float64, error) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("bad coordinate %q", v)
+	}
+	var c float64
+	for i, scale := range []float64{1, 60, 3600} {
+		r, err := rational(parts[i])
+		if err != nil {
+			return 0, err
+		}
+		c += r / scale
+	}
+	if strings.TrimSpace(ref) == neg {
+		c = -c
+	}
+	return c, nil
+}
+
+// rational reads an EXIF rational, n/d.
+func rational(s string) (float64, error) {
+	nd := strings.Split(strings.TrimSpace(s), "/")
+	n, err := strconv.ParseFloat(nd[0], 64)
+	if err != nil || len(nd) == 1 {
+		return n, err
+	}
+	d, err := strconv.ParseFloat(nd[1], 64)
+	if err != nil || d == 0 {
+		return 0, fmt.Errorf("bad rational %q", s)
+	}
+	return n / d, nil
+}
@@ -1,23 +1,22 @@
 package main
 
 import (
-	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"runtime"
 	"strings"
 
-	"code.google.com/p/go.net/context"
 	auth "code.google.com/p/google-api-go-client/oauth2/v2"
 	"github.com/gographics/imagick/imagick"
 	"github.com/golang/oauth2/google"
 	"google.golang.org/cloud"
-	"google.golang.org/cloud/storage"
 )
 
 const (
@@ -29,22 +28,26 @@ const (
 )
 
 var (
-	account = flag.String("account", "service-account.json", "path to service account JSON file")
-
-	// map with the suffixes and sizes to generate
-	sizes = map[string]struct{ x, y uint }{
-		"a": {480, 800},
-		"b": {768, 768},
-		"c": {1080, 1080},
-		"d": {1440, 1440},
-		"e": {1200, 1200},
-		"f": {1536, 1536},
-		"g": {720, 720},
-		"h": {640, 640},
-		"i": {750, 750},
-	}
-
-	ctx    context.Context
+	account  = flag.String("account", "service-account.json", "path to service account JSON file, or empty for none")
+	localDir = flag.String("local", "", "keep images in this directory instead of Cloud Storage")
+	push     = flag.String("push", pushURL, "where to tell endpoints a photo is ready")
+	noAuth   = flag.Bool("noauth", false, "don't check who is calling us, for testing")
+	sidecar  = flag.Bool("sidecar", false, "tell endpoints when and where photos were taken")
+
+	workers        = flag.Int("workers", 2, "how many photos to work on at once")
+	maxAttempts    = flag.Int("attempts", 8, "how many times to try a photo before giving up on it")
+	maxAnnounces   = flag.Int("announces", 10, "how many times to try telling endpoints about a photo")
+	renderers      = flag.Int("renderers", runtime.NumCPU(), "how many renditions to make at once, across all requests")
+	memoryLimit    = flag.Int64("memory", 256<<20, "bytes of memory ImageMagick may use before it uses disk")
+	areaLimit      = flag.Int64("area", 64<<20, "pixels ImageMagick may keep in memory before it uses disk")
+	maxBytes       = flag.Int64("maxbytes", 20<<20, "largest upload we will read")
+	maxPixels      = flag.Uint64("maxpixels", 50e6, "most pixels an upload may have, in all its frames")
+	animated       = flag.String("animated", animatedFirst, "what to do with animated uploads, first or reject")
+	stateBucket    = flag.String("state", "abelana-resizer", "private bucket for the queue and each photo's status, which has its location in it")
+	renditionsFile = flag.String("renditions", "", "JSON file of the renditions to make, see rendition")
+	renditions     = defaultRenditions
+
+	store  objectStore
 	client *http.Client
 )
 
@@ -52,17 +55,57 @@ func main() {
 	flag.Parse()
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	ctx = cloud.NewContext(projectID, &http.Client{
-		Transport: google.NewComputeEngineConfig("").NewTransport(),
-	})
+	if *renditionsFile != "" {
+		rs, err := loadRenditions(*renditionsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		renditions = rs
+	} else if err := checkRenditions(renditions); err != nil {
+		log.Fatal(err)
+	}
 
-	config, err := google.NewServiceAccountJSONConfig(*account, "https://www.googleapis.com/auth/userinfo.email")
-	if err != nil {
+	// The output bucket is public, and the state holds where photos were taken.
+	if *stateBucket == outputBucket {
+		log.Fatalf("-state must not be the public bucket %v", outputBucket)
+	}
+	if *animated != animatedFirst && *animated != animatedReject {
+		log.Fatalf("-animated must be %v or %v", animatedFirst, animatedReject)
+	}
+
+	imagick.Initialize()
+	defer imagick.Terminate()
+	if err := limitResources(*memoryLimit, *areaLimit); err != nil {
+		log.Fatal(err)
+	}
+	startRenderers(*renderers)
+
+	if *localDir != "" {
+		store = localStore(*localDir)
+	} else {
+		store = gcsStore{cloud.NewContext(projectID, &http.Client{
+			Transport: google.NewComputeEngineConfig("").NewTransport(),
+		})}
+	}
+
+	client = http.DefaultClient
+	if *account != "" {
+		config, err := google.NewServiceAccountJSONConfig(*account, "https://www.googleapis.com/auth/userinfo.email")
+		if err != nil {
+			log.Fatal(err)
+		}
+		client = &http.Client{Transport: config.NewTransport()}
+	}
+
+	startWorkers(*workers)
+	if err := recoverJobs(); err != nil {
 		log.Fatal(err)
 	}
-	client = &http.Client{Transport: config.NewTransport()}
 
 	http.HandleFunc("/healthcheck", func(http.ResponseWriter, *http.Request) {})
+	http.HandleFunc("/backfill", backfillHandler)
+	http.HandleFunc("/status", statusHandler)
+	http.HandleFunc("/deadletter", deadLetterHandler)
 	http.HandleFunc("/", notificationHandler)
 	log.Println("server listening on", listenAddress)
 
@@ -78,28 +121,108 @@ func notificationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if ok, err := authorized(r.Header.Get("Authorization")); !ok {
-		if err != nil {
-			log.Printf("authorize: %v", err)
-		}
-		http.Error(w, "you're not authorized", http.StatusForbidden)
+	if !allowed(w, r) {
 		return
 	}
 
-	start := time.Now()
-	defer func() { log.Printf("%v: processed in %v", name, time.Since(start)) }()
+	// The notice only waits a minute, so we take the job and do it in our own time.
+	if err := enqueue(&job{Bucket: bucket, Name: name}); err != nil {
+		log.Println(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
 
-	if err := processImage(bucket, name); err != nil {
-		// TODO: should this remove uploaded images?
+// statusHandler says how the upload called name is getting on, as JSON.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	if name == "" {
+		http.Error(w, "missing name", http.StatusBadRequest)
+		return
+	}
+	if !allowed(w, r) {
+		return
+	}
+	var v struct {
+		*photoStatus
+		Job *job `json:"job,omitempty"` // if it's still to be done, or announced
+	}
+	var err error
+	if v.photoStatus, err = readStatus(name); err == nil {
+		v.Job, err = readJob(name)
+	}
+	if err != nil {
 		log.Println(err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if v.photoStatus == nil && v.Job == nil {
+		http.Error(w, "no such upload", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
 
-	if err := notifyDone(name); err != nil {
+// deadLetterHandler lists the photos we made but could never announce, as JSON, or with POST,
+// tries announcing the one called name again.
+func deadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowed(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		names, err := store.List(*stateBucket, deadLetterPrefix)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range names {
+			names[i] = strings.TrimPrefix(names[i], deadLetterPrefix)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(names)
+		return
+	}
+
+	name := r.PostFormValue("name")
+	b, err := store.Read(*stateBucket, deadLetterPrefix+name, maxStatusBytes)
+	if err == errNotFound {
+		http.Error(w, "no such dead letter", http.StatusNotFound)
+		return
+	}
+	var j job
+	if err == nil {
+		err = json.Unmarshal(b, &j)
+	}
+	if err == nil {
+		err = enqueue(&job{Bucket: j.Bucket, Name: j.Name, Announce: true})
+	}
+	if err == nil {
+		err = store.Delete(*stateBucket, deadLetterPrefix+name)
+	}
+	if err != nil {
 		log.Println(err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// allowed checks that endpoints, or the notice, is calling us, and says so if not.
+func allowed(w http.ResponseWriter, r *http.Request) bool {
+	if *noAuth {
+		return true
+	}
+	ok, err := authorized(r.Header.Get("Authorization"))
+	if err != nil {
+		log.Printf("authorize: %v", err)
+	}
+	if !ok {
+		http.Error(w, "you're not authorized", http.StatusForbidden)
+	}
+	return ok
 }
 
 func authorized(token string) (ok bool, err error) {
@@ -117,73 +240,153 @@ func authorized(token string) (ok bool, err error) {
 	return err == nil && tok.Email == authEmail, err
 }
 
-func processImage(bucket, name string) error {
-	r, err := storage.NewReader(ctx, bucket, name)
-	if err != nil {
-		return fmt.Errorf("storage reader: %v", err)
+// photoInfo is what we tell endpoints about a photo, so clients can lay out their timeline
+// before the images arrive.
+type photoInfo struct {
+	Width, Height uint // of the original, the right way up
+
+	// From the EXIF, only with -sidecar
+	Taken       int64 // seconds since the epoch, in the camera's time zone
+	Lat, Lng    float64
+	HasLocation bool
+}
+
+// values are how photoInfo is sent.
+func (pi *photoInfo) values() url.Values {
+	v := url.Values{
+		"width":  {strconv.FormatUint(uint64(pi.Width), 10)},
+		"height": {strconv.FormatUint(uint64(pi.Height), 10)},
 	}
-	img, err := ioutil.ReadAll(r)
-	r.Close()
+	if pi.Taken != 0 {
+		v.Set("taken", strconv.FormatInt(pi.Taken, 10))
+	}
+	if pi.HasLocation {
+		v.Set("lat", strconv.FormatFloat(pi.Lat, 'f', -1, 64))
+		v.Set("lng", strconv.FormatFloat(pi.Lng, 'f', -1, 64))
+	}
+	return v
+}
+
+// processImage makes each of the renditions of the image, and says what it wrote.  It is all or
+// nothing: if one rendition fails, the others are deleted.
+func processImage(bucket, name string, rs []rendition) (*photoInfo, []string, error) {
+	img, err := store.Read(bucket, name, *maxBytes)
 	if err != nil {
-		return fmt.Errorf("read image: %v", err)
+		return nil, nil, fmt.Errorf("read image: %v", err)
 	}
 
-	wand := imagick.NewMagickWand()
+	wand, err := readImage(img)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer wand.Destroy()
 
-	wand.ReadImageBlob(img)
-	if err := wand.SetImageFormat("WEBP"); err != nil {
-		return fmt.Errorf("set WEBP format: %v", err)
-	}
-
-	errc := make(chan error, len(sizes))
-	for suffix, size := range sizes {
-		go func(wand *imagick.MagickWand, suffix string, x, y uint) {
-			errc <- func() error {
-				defer wand.Destroy()
-
-				if err := wand.AdaptiveResizeImage(size.x, size.y); err != nil {
-					return fmt.Errorf("resize: %v", err)
-				}
-
-				target := name
-				if sep := strings.LastIndex(target, "."); sep >= 0 {
-					target = target[:sep]
-				}
-				target = fmt.Sprintf("%s_%s.webp", target, suffix)
-
-				w := storage.NewWriter(ctx, outputBucket, target, nil)
-				if _, err := w.Write(wand.GetImageBlob()); err != nil {
-					return fmt.Errorf("new writer: %v", err)
-				}
-				if err := w.Close(); err != nil {
-					return fmt.Errorf("close object writer: %v", err)
-				}
-				if _, err := w.Object(); err != nil {
-					return fmt.Errorf("write op: %v", err)
-				}
-				return nil
-			}()
-		}(wand.Clone(), suffix, size.x, size.y)
-	}
-
-	for _ = range sizes {
-		if err := <-errc; err != nil {
-			return err
+	if err := autoOrient(wand); err != nil {
+		return nil, nil, err
+	}
+	info := &photoInfo{Width: wand.GetImageWidth(), Height: wand.GetImageHeight()}
+	if *sidecar {
+		captureInfo(wand, info)
+	}
+	// Nothing from the camera goes in the renditions, GPS and serial numbers included, but the
+	// color profile has to stay or the colors will be off.
+	icc := wand.GetImageProfile("icc")
+	if err := wand.StripImage(); err != nil {
+		return nil, nil, fmt.Errorf("strip: %v", err)
+	}
+	if len(icc) > 0 {
+		if err := wand.SetImageProfile("icc", []byte(icc)); err != nil {
+			return nil, nil, fmt.Errorf("color profile: %v", err)
 		}
 	}
-	return nil
+
+	// Each rendition gets its own copy, made as a renderer becomes free, so no two renderers
+	// share a wand and each has its own rendition.
+	done := make(chan renderResult, len(rs))
+	for _, r := range rs {
+		renderJobs <- renderJob{wand.Clone(), r, name, done}
+	}
+
+	var written []string
+	for _ = range rs {
+		res := <-done
+		written = append(written, res.written...)
+		if res.err != nil && err == nil {
+			err = res.err
+		}
+	}
+	if err != nil {
+		for _, o := range written {
+			if err := store.Delete(outputBucket, o); err != nil {
+				log.Printf("%v: clean up: %v", name, err)
+			}
+		}
+		return nil, nil, err
+	}
+	return info, written, nil
+}
+
+// backfillHandler makes one rendition for every photo in a bucket, after a new rendition has been
+// added.  The photos have already been announced, so endpoints isn't told.
+func backfillHandler(w http.ResponseWriter, r *http.Request) {
+	bucket, suffix := r.PostFormValue("bucket"), r.PostFormValue("rendition")
+	if bucket == "" || suffix == "" {
+		http.Error(w, "missing bucket or rendition", http.StatusBadRequest)
+		return
+	}
+	if !allowed(w, r) {
+		return
+	}
+	rd, ok := findRendition(suffix)
+	if !ok {
+		http.Error(w, "no such rendition", http.StatusNotFound)
+		return
+	}
+	names, err := store.List(bucket, "")
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	go func() {
+		start := time.Now()
+		failed := 0
+		for _, name := range names {
+			if _, _, err := processImage(bucket, name, []rendition{rd}); err != nil {
+				log.Printf("backfill %v %v: %v", suffix, name, err)
+				failed++
+			}
+		}
+		log.Printf("backfill %v: %d photos, %d failed, in %v", suffix, len(names), failed, time.Since(start))
+	}()
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "backfilling %d photos\n", len(names))
+}
+
+// notifyDone tells endpoints the photo is ready.  It may be told more than once, but key is
+// always the same.
+func notifyDone(name, key string, info *photoInfo) error {
+	return notify(name, key, info.values())
+}
+
+// notifyRejected tells endpoints we won't make renditions of the photo, and why.
+func notifyRejected(name, key, reason string) error {
+	return notify(name, key, url.Values{"rejected": {reason}})
 }
 
-func notifyDone(name string) (err error) {
-	req, err := http.NewRequest("POST", pushURL+name, &bytes.Buffer{})
+func notify(name, key string, v url.Values) error {
+	req, err := http.NewRequest("POST", *push+name, strings.NewReader(v.Encode()))
 	if err != nil {
 		return err
 	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Idempotency-Key", key)
 	res, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("photo push: %v", err)
 	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
 		return fmt.Errorf("photo push status: %v", res.Status)
 	}
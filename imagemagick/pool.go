@@ -0,0 +1,71 @@
+package main
+
+import "github.com/gographics/imagick/imagick"
+
+// renderJob is one rendition of one upload, for a renderer to make and write.  The renderer
+// destroys the wand, which is a copy of the upload that is its alone.
+type renderJob struct {
+	wand *imagick.MagickWand
+	r    rendition
+	name string
+	done chan<- renderResult
+}
+
+// renderResult is what a renderJob wrote, which is there even if it failed part way.
+type renderResult struct {
+	written []string
+	err     error
+}
+
+// renderJobs is shared by every request, so only as many renditions are made at once as there
+// are renderers, however many photos come in.
+var renderJobs = make(chan renderJob)
+
+// startRenderers starts n renderers, which run until the server stops.
+func startRenderers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := range renderJobs {
+				j.done <- j.run()
+			}
+		}()
+	}
+}
+
+func (j renderJob) run() (res renderResult) {
+	defer j.wand.Destroy()
+
+	out, err := j.r.render(j.wand)
+	if err != nil {
+		res.err = err
+		return
+	}
+	for f, b := range out {
+		target := j.r.target(j.name, f)
+		if res.err = store.Write(outputBucket, target, formats[f].contentType, b); res.err != nil {
+			return
+		}
+		res.written = append(res.written, target)
+	}
+	return
+}
+
+// limitResources stops ImageMagick from taking more than memory bytes of memory, or keeping more
+// than area pixels in memory, between all the images it has open; past those it uses disk, which
+// is slow but doesn't kill the server.  Each renderer is single threaded, since by default there
+// is already one per CPU.
+func limitResources(memory, area int64) error {
+	wand := imagick.NewMagickWand()
+	defer wand.Destroy()
+
+	if err := wand.SetResourceLimit(imagick.RESOURCE_MEMORY, memory); err != nil {
+		return err
+	}
+	if err := wand.SetResourceLimit(imagick.RESOURCE_MAP, 2*memory); err != nil {
+		return err
+	}
+	if err := wand.SetResourceLimit(imagick.RESOURCE_AREA, area); err != nil {
+		return err
+	}
+	return wand.SetResourceLimit(imagick.RESOURCE_THREAD, 1)
+}
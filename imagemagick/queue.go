@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// job is an upload waiting to be made into renditions and announced.  It's kept as queue/name in
+// the state bucket until it's done, so the queue survives a restart.
+type job struct {
+	Bucket   string    `json:"bucket"`
+	Name     string    `json:"name"`
+	Announce bool      `json:"announce,omitempty"` // it's been made, it only has to be announced
+	Attempts int       `json:"attempts"`           // at the current step
+	NextTry  time.Time `json:"nextTry"`
+	Error    string    `json:"error,omitempty"` // from the last attempt
+}
+
+// Retry backoff, each retry waits twice as long as the last.  Announcing is cheap, so it's
+// retried sooner than making the renditions.
+const (
+	firstRetry         = 30 * time.Second
+	firstAnnounceRetry = 5 * time.Second
+	maxRetry           = time.Hour
+)
+
+// Jobs waiting are kept in queuePrefix, photos that were made but never announced in
+// deadLetterPrefix, until someone sends them again.
+const (
+	queuePrefix      = "queue/"
+	deadLetterPrefix = "deadletter/"
+)
+
+var (
+	// jobQueue hands jobs that are due to the workers.
+	jobQueue = make(chan *job)
+
+	// queued are the jobs we have, by name, so a notice that is sent twice is only done once.
+	queued   = make(map[string]bool)
+	queuedMu sync.Mutex
+)
+
+// enqueue takes on a job.  Once it returns without error, the job will be done even if we restart.
+func enqueue(j *job) error {
+	queuedMu.Lock()
+	dup := queued[j.Name]
+	queued[j.Name] = true
+	queuedMu.Unlock()
+	if dup {
+		return nil
+	}
+	if err := saveJob(j); err != nil {
+		forget(j)
+		return err
+	}
+	schedule(j, 0)
+	return nil
+}
+
+// schedule gives j to a worker after d.
+func schedule(j *job, d time.Duration) {
+	time.AfterFunc(d, func() { jobQueue <- j })
+}
+
+func forget(j *job) {
+	queuedMu.Lock()
+	delete(queued, j.Name)
+	queuedMu.Unlock()
+}
+
+// startWorkers starts n workers, each does one job at a time.
+func startWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := range jobQueue {
+				j.attempt()
+			}
+		}()
+	}
+}
+
+// attempt does the job, and if that fails tries again later, until it has had maxAttempts at
+// making the renditions or maxAnnounces at announcing them.  A photo that could never be made is
+// announced as rejected, so its owner isn't left waiting, and one that could never be announced
+// is kept as a dead letter.
+func (j *job) attempt() {
+	start := time.Now()
+	err := j.run()
+	log.Printf("%v: attempt %d in %v", j.Name, j.Attempts+1, time.Since(start))
+	if err != nil {
+		j.Attempts++
+		j.Error = err.Error()
+		log.Printf("%v: %v", j.Name, err)
+		limit, wait := *maxAttempts, firstRetry
+		if j.Announce {
+			limit, wait = *maxAnnounces, firstAnnounceRetry
+		}
+		if j.Attempts < limit {
+			wait <<= uint(j.Attempts - 1)
+			if wait > maxRetry || wait <= 0 {
+				wait = maxRetry
+			}
+			j.NextTry = time.Now().Add(wait).UTC()
+			if err := saveJob(j); err != nil {
+				log.Println(err.Error())
+			}
+			schedule(j, wait)
+			return
+		}
+		log.Printf("%v: giving up after %d attempts", j.Name, j.Attempts)
+		if !j.Announce {
+			abandon(j.Name, j.Error)
+			j.Announce, j.Attempts = true, 0
+			if err := saveJob(j); err != nil {
+				log.Println(err.Error())
+			}
+			schedule(j, 0)
+			return
+		}
+		deadLetter(j)
+	}
+	if err := store.Delete(*stateBucket, queuePrefix+j.Name); err != nil {
+		log.Println(err.Error())
+	}
+	forget(j)
+}
+
+// run makes the renditions, if they haven't been made, and tells endpoints.
+func (j *job) run() error {
+	st, err := process(j.Bucket, j.Name)
+	if err != nil {
+		return err
+	}
+	if st.State != stateDone && st.State != stateRejected && st.State != stateAbandoned {
+		return fmt.Errorf("%v", st.Error)
+	}
+	if !j.Announce {
+		j.Announce, j.Attempts = true, 0
+	}
+	switch st.State {
+	case stateRejected:
+		return notifyRejected(j.Name, st.Key, st.Error)
+	case stateAbandoned:
+		// The error is ours, not the photo's, so its owner isn't told what it was.
+		return notifyRejected(j.Name, st.Key, "it couldn't be processed")
+	}
+	return notifyDone(j.Name, st.Key, st.Info)
+}
+
+// deadLetter keeps a job we gave up announcing.
+func deadLetter(j *job) {
+	b, err := json.Marshal(j)
+	if err == nil {
+		err = store.Write(*stateBucket, deadLetterPrefix+j.Name, "application/json", b)
+	}
+	if err != nil {
+		log.Printf("%v: dead letter: %v", j.Name, err)
+	}
+}
+
+func saveJob(j *job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return store.Write(*stateBucket, queuePrefix+j.Name, "application/json", b)
+}
+
+// readJob is the job for the upload called name, or nil if there isn't one.
+func readJob(name string) (*job, error) {
+	b, err := store.Read(*stateBucket, queuePrefix+name, maxStatusBytes)
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var j job
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, fmt.Errorf("job %v: %v", name, err)
+	}
+	return &j, nil
+}
+
+// recoverJobs picks up the jobs we had when we last stopped.
+func recoverJobs() error {
+	names, err := store.List(*stateBucket, queuePrefix)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		j, err := readJob(strings.TrimPrefix(n, queuePrefix))
+		if err != nil || j == nil {
+			log.Printf("recover %v: %v", n, err)
+			continue
+		}
+		queuedMu.Lock()
+		queued[j.Name] = true
+		queuedMu.Unlock()
+		schedule(j, j.NextTry.Sub(time.Now()))
+	}
+	log.Printf("recovered %d jobs", len(names))
+	return nil
+}
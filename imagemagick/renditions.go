@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/gographics/imagick/imagick"
+)
+
+// rendition is one of the images we make from each upload, it's written as name_suffix.ext.
+type rendition struct {
+	Suffix  string   `json:"suffix"`
+	Width   uint     `json:"width"` // the box the image has to go in
+	Height  uint     `json:"height"`
+	Mode    string   `json:"mode"`    // how it goes in the box, see resize
+	Crop    string   `json:"crop"`    // which part we keep in modeCrop, see crop
+	Formats []string `json:"formats"` // webp, jpeg or png, each is written
+	Format  string   `json:"format"`  // the one format, from before there could be several
+	Quality uint     `json:"quality"` // 1-100, 0 for ImageMagick's default
+	Sharpen float64  `json:"sharpen"` // unsharp mask sigma, 0 for none
+
+	// Progressive JPEGs show something while they load, at the cost of a few bytes.
+	Progressive bool `json:"progressive"`
+}
+
+// Resize modes
+const (
+	modeFit     = "fit"     // as big as it can be inside the box, the default
+	modeFill    = "fill"    // as small as it can be and still cover the box
+	modeCrop    = "crop"    // fill, then cut off what's outside the box
+	modeStretch = "stretch" // exactly the box, whatever that does to the aspect ratio
+)
+
+// defaultRenditions are what we made before renditions could be configured, but without
+// stretching the photos.
+var defaultRenditions = []rendition{
+	{Suffix: "a", Width: 480, Height: 800},
+	{Suffix: "b", Width: 768, Height: 768},
+	{Suffix: "c", Width: 1080, Height: 1080},
+	{Suffix: "d", Width: 1440, Height: 1440},
+	{Suffix: "e", Width: 1200, Height: 1200},
+	{Suffix: "f", Width: 1536, Height: 1536},
+	{Suffix: "g", Width: 720, Height: 720},
+	{Suffix: "h", Width: 640, Height: 640},
+	{Suffix: "i", Width: 750, Height: 750},
+}
+
+var formats = map[string]struct{ ext, contentType string }{
+	"webp": {"webp", "image/webp"},
+	"jpeg": {"jpg", "image/jpeg"},
+	"png":  {"png", "image/png"},
+}
+
+// defaultFormats is WebP, which is smallest, and JPEG for clients that can't show WebP.  Endpoints
+// picks between them for each client.
+var defaultFormats = []string{"webp", "jpeg"}
+
+// uploadExts are the extensions an upload may have, which aren't part of its photoid.
+var uploadExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true}
+
+// photoName is the photoid of the upload called name.  Endpoints names uploads userid.random, so
+// the photoid has a dot in it, and only an image extension, if there is one, is dropped.
+func photoName(name string) string {
+	if ext := path.Ext(name); uploadExts[strings.ToLower(ext)] {
+		return strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// loadRenditions reads the renditions from a JSON file, which is a list of rendition.
+func loadRenditions(path string) ([]rendition, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rs []rendition
+	if err := json.Unmarshal(b, &rs); err != nil {
+		return nil, fmt.Errorf("parse %v: %v", path, err)
+	}
+	if err := checkRenditions(rs); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return rs, nil
+}
+
+// checkRenditions fills in the defaults, and makes sure the rest makes sense.
+func checkRenditions(rs []rendition) error {
+	seen := make(map[string]bool)
+	for i := range rs {
+		r := &rs[i]
+		if r.Suffix == "" || seen[r.Suffix] {
+			return fmt.Errorf("rendition %d needs a suffix of its own", i)
+		}
+		seen[r.Suffix] = true
+		if r.Width == 0 || r.Height == 0 {
+			return fmt.Errorf("rendition %v needs a width and height", r.Suffix)
+		}
+		switch r.Mode {
+		case "":
+			r.Mode = modeFit
+		case modeFit, modeFill, modeCrop, modeStretch:
+		default:
+			return fmt.Errorf("rendition %v has unknown mode %q", r.Suffix, r.Mode)
+		}
+		switch r.Crop {
+		case "":
+			r.Crop = cropCenter
+		case cropCenter, cropEntropy:
+		default:
+			return fmt.Errorf("rendition %v has unknown crop %q", r.Suffix, r.Crop)
+		}
+		if r.Format != "" {
+			if len(r.Formats) != 0 {
+				return fmt.Errorf("rendition %v has both format and formats", r.Suffix)
+			}
+			r.Formats = []string{r.Format}
+		}
+		if len(r.Formats) == 0 {
+			r.Formats = defaultFormats
+		}
+		for _, f := range r.Formats {
+			if _, ok := formats[f]; !ok {
+				return fmt.Errorf("rendition %v has unknown format %q", r.Suffix, f)
+			}
+		}
+		if r.Quality > 100 {
+			return fmt.Errorf("rendition %v has quality over 100", r.Suffix)
+		}
+	}
+	return nil
+}
+
+// findRendition finds a rendition by its suffix.
+func findRendition(suffix string) (rendition, bool) {
+	for _, r := range renditions {
+		if r.Suffix == suffix {
+			return r, true
+		}
+	}
+	return rendition{}, false
+}
+
+// target is the name of r in format made from the upload called name.
+func (r rendition) target(name, format string) string {
+	return fmt.Sprintf("%s_%s.%s", photoName(name), r.Suffix, formats[format].ext)
+}
+
+// render makes r from the image in wand, which it changes, in each of r's formats.
+func (r rendition) render(wand *imagick.MagickWand) (map[string][]byte, error) {
+	if err := resize(wand, r); err != nil {
+		return nil, fmt.Errorf("resize %v: %v", r.Suffix, err)
+	}
+	if r.Sharpen > 0 {
+		if err := wand.UnsharpMaskImage(0, r.Sharpen, 1, 0.05); err != nil {
+			return nil, fmt.Errorf("sharpen %v: %v", r.Suffix, err)
+		}
+	}
+	if r.Quality > 0 {
+		if err := wand.SetImageCompressionQuality(r.Quality); err != nil {
+			return nil, fmt.Errorf("quality %v: %v", r.Suffix, err)
+		}
+	}
+	out := make(map[string][]byte, len(r.Formats))
+	for _, f := range r.Formats {
+		b, err := r.encode(wand, f)
+		if err != nil {
+			return nil, err
+		}
+		out[f] = b
+	}
+	return out, nil
+}
+
+// encode writes the image in wand as format.
+func (r rendition) encode(wand *imagick.MagickWand, format string) ([]byte, error) {
+	if err := wand.SetImageFormat(strings.ToUpper(format)); err != nil {
+		return nil, fmt.Errorf("set %v format: %v", format, err)
+	}
+	interlace := imagick.INTERLACE_NO
+	if format == "jpeg" && r.Progressive {
+		interlace = imagick.INTERLACE_PLANE
+	}
+	if err := wand.SetImageInterlaceScheme(interlace); err != nil {
+		return nil, fmt.Errorf("interlace %v: %v", r.Suffix, err)
+	}
+	return wand.GetImageBlob(), nil
+}
+
+// resize puts the image in r's box, keeping its aspect ratio unless r is modeStretch.  We never
+// make an image bigger than it was.
+func resize(wand *imagick.MagickWand, r rendition) error {
+	w, h, mode := r.Width, r.Height, r.Mode
+	if mode == modeStretch {
+		return wand.AdaptiveResizeImage(w, h)
+	}
+	iw, ih := wand.GetImageWidth(), wand.GetImageHeight()
+	if iw == 0 || ih == 0 {
+		return fmt.Errorf("empty image")
+	}
+	sx, sy := float64(w)/float64(iw), float64(h)/float64(ih)
+	scale := sx
+	if (mode == modeFit) == (sy < sx) {
+		scale = sy
+	}
+	if scale < 1 {
+		nw, nh := uint(float64(iw)*scale+0.5), uint(float64(ih)*scale+0.5)
+		if nw == 0 {
+			nw = 1
+		}
+		if nh == 0 {
+			nh = 1
+		}
+		if err := wand.ResizeImage(nw, nh, imagick.FILTER_LANCZOS, 1); err != nil {
+			return err
+		}
+	}
+	if mode != modeCrop {
+		return nil
+	}
+	iw, ih = wand.GetImageWidth(), wand.GetImageHeight()
+	if iw <= w && ih <= h {
+		return nil
+	}
+	if w > iw {
+		w = iw
+	}
+	if h > ih {
+		h = ih
+	}
+	return crop(wand, w, h, r.Crop)
+}
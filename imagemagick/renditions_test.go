@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/gographics/imagick/imagick"
+)
+
+func TestMain(m *testing.M) {
+	imagick.Initialize()
+	startRenderers(testRenderers)
+	code := m.Run()
+	imagick.Terminate()
+	os.Exit(code)
+}
+
+// testRenderers is how many renderers the tests share, more than one so renditions of the same
+// photo are made at the same time.
+const testRenderers = 4
+
+// testUpload is a plain w x h PNG.
+func testUpload(t *testing.T, w, h uint) []byte {
+	bg := imagick.NewPixelWand()
+	defer bg.Destroy()
+	bg.SetColor("gray")
+
+	wand := imagick.NewMagickWand()
+	defer wand.Destroy()
+	if err := wand.NewImage(w, h, bg); err != nil {
+		t.Fatalf("new %dx%d image: %v", w, h, err)
+	}
+	if err := wand.SetImageFormat("PNG"); err != nil {
+		t.Fatalf("set format: %v", err)
+	}
+	return wand.GetImageBlob()
+}
+
+// checkProcessed makes the renditions rs from a w x h upload, the way the resizer does, and
+// checks every object written in each format of r is want[r.Suffix].
+func checkProcessed(t *testing.T, rs []rendition, w, h uint, want map[string][2]uint) {
+	if err := checkRenditions(rs); err != nil {
+		t.Fatal(err)
+	}
+	store = localStore(t.TempDir())
+	name := fmt.Sprintf("user.%dx%d", w, h)
+	if err := store.Write("uploads", name, "image/png", testUpload(t, w, h)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, written, err := processImage("uploads", name, rs)
+	if err != nil {
+		t.Fatalf("%v: %v", name, err)
+	}
+	n := 0
+	for _, r := range rs {
+		n += len(r.Formats)
+	}
+	if len(written) != n {
+		t.Errorf("%v: wrote %d objects, want %d", name, len(written), n)
+	}
+	for _, r := range rs {
+		for _, f := range r.Formats {
+			target := r.target(name, f)
+			b, err := store.Read(outputBucket, target, *maxBytes)
+			if err != nil {
+				t.Errorf("%v: %v", target, err)
+				continue
+			}
+			ping := imagick.NewMagickWand()
+			if err := ping.PingImageBlob(b); err != nil {
+				t.Errorf("%v: %v", target, err)
+			} else if gw, gh := ping.GetImageWidth(), ping.GetImageHeight(); gw != want[r.Suffix][0] || gh != want[r.Suffix][1] {
+				t.Errorf("%v is %dx%d, want %dx%d", target, gw, gh, want[r.Suffix][0], want[r.Suffix][1])
+			}
+			ping.Destroy()
+		}
+	}
+}
+
+func TestDefaultRenditionSizes(t *testing.T) {
+	portrait := map[string][2]uint{
+		"a": {480, 640}, "b": {576, 768}, "c": {810, 1080}, "d": {1080, 1440}, "e": {900, 1200},
+		"f": {1152, 1536}, "g": {540, 720}, "h": {480, 640}, "i": {563, 750},
+	}
+	landscape := map[string][2]uint{
+		"a": {480, 360}, "b": {768, 576}, "c": {1080, 810}, "d": {1440, 1080}, "e": {1200, 900},
+		"f": {1536, 1152}, "g": {720, 540}, "h": {640, 480}, "i": {750, 563},
+	}
+	if len(portrait) != len(defaultRenditions) {
+		t.Fatalf("%d default renditions, but sizes for %d", len(defaultRenditions), len(portrait))
+	}
+	checkProcessed(t, append([]rendition(nil), defaultRenditions...), 3000, 4000, portrait)
+	checkProcessed(t, append([]rendition(nil), defaultRenditions...), 4000, 3000, landscape)
+}
+
+func TestRenditionModes(t *testing.T) {
+	landscape := []rendition{
+		{Suffix: "fit", Width: 600, Height: 600},
+		{Suffix: "fill", Width: 600, Height: 600, Mode: modeFill},
+		{Suffix: "stretch", Width: 300, Height: 500, Mode: modeStretch},
+	}
+	checkProcessed(t, landscape, 4000, 3000, map[string][2]uint{
+		"fit": {600, 450}, "fill": {800, 600}, "stretch": {300, 500},
+	})
+
+	portrait := []rendition{
+		{Suffix: "crop", Width: 600, Height: 600, Mode: modeCrop},
+		{Suffix: "entropy", Width: 400, Height: 300, Mode: modeCrop, Crop: cropEntropy},
+	}
+	checkProcessed(t, portrait, 3000, 4000, map[string][2]uint{
+		"crop": {600, 600}, "entropy": {400, 300},
+	})
+
+	small := []rendition{{Suffix: "small", Width: 1000, Height: 1000}}
+	checkProcessed(t, small, 400, 300, map[string][2]uint{"small": {400, 300}}) // never made bigger
+}
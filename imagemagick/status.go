@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// photoStatus is what has become of an upload, kept as status/name in the state bucket.  A job
+// is tried until it's announced, so a photo that is done isn't made again, only announced.
+type photoStatus struct {
+	State      string     `json:"state"`
+	Error      string     `json:"error,omitempty"` // why it failed or was rejected
+	Info       *photoInfo `json:"info,omitempty"`
+	Renditions []string   `json:"renditions,omitempty"` // the objects we wrote
+	Key        string     `json:"key,omitempty"`        // sent with each announcement, so endpoints can spot repeats
+	Updated    time.Time  `json:"updated"`
+}
+
+// States of a photo
+const (
+	stateProcessing = "processing" // or we stopped part way, it's made again
+	stateDone       = "done"
+	stateFailed     = "failed" // it's made again if its job has attempts left
+	stateRejected   = "rejected"
+	stateAbandoned  = "abandoned" // it failed every attempt, endpoints is told it was rejected
+)
+
+// maxStatusBytes is more than a status could ever be.
+const maxStatusBytes = 64 << 10
+
+func statusName(name string) string {
+	return "status/" + name
+}
+
+// readStatus is the status of the upload called name, or nil if we've never seen it.
+func readStatus(name string) (*photoStatus, error) {
+	b, err := store.Read(*stateBucket, statusName(name), maxStatusBytes)
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st photoStatus
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("status of %v: %v", name, err)
+	}
+	return &st, nil
+}
+
+// writeStatus records st, errors are only logged as the photo is done with either way.
+func writeStatus(name string, st *photoStatus) {
+	st.Updated = time.Now().UTC()
+	b, err := json.Marshal(st)
+	if err == nil {
+		err = store.Write(*stateBucket, statusName(name), "application/json", b)
+	}
+	if err != nil {
+		log.Printf("%v: write status: %v", name, err)
+	}
+}
+
+// process makes the renditions of an upload, unless that's been done already, and records
+// how it went.
+func process(bucket, name string) (*photoStatus, error) {
+	st, err := readStatus(name)
+	if err != nil {
+		return nil, err
+	}
+	if st != nil && (st.State == stateDone || st.State == stateRejected || st.State == stateAbandoned) {
+		log.Printf("%v: already %v", name, st.State)
+		return st, nil
+	}
+
+	writeStatus(name, &photoStatus{State: stateProcessing})
+	info, written, err := processImage(bucket, name, renditions)
+	switch err := err.(type) {
+	case nil:
+		st = &photoStatus{State: stateDone, Info: info, Renditions: written}
+	case rejection:
+		log.Printf("%v: %v", name, err)
+		if err := store.Delete(bucket, name); err != nil {
+			log.Println(err.Error())
+		}
+		st = &photoStatus{State: stateRejected, Error: err.reason}
+	default:
+		log.Println(err.Error())
+		st = &photoStatus{State: stateFailed, Error: err.Error()}
+	}
+	if st.State != stateFailed {
+		st.Key = newKey(name)
+	}
+	writeStatus(name, st)
+	return st, nil
+}
+
+// abandon gives up on making the renditions of an upload, which has failed for reason.  The
+// upload is kept, in case whatever went wrong can be put right.
+func abandon(name, reason string) {
+	writeStatus(name, &photoStatus{State: stateAbandoned, Error: reason, Key: newKey(name)})
+}
+
+// newKey is the idempotency key for announcing what became of the upload called name.
+func newKey(name string) string {
+	return fmt.Sprintf("%v@%d", name, time.Now().UnixNano())
+}
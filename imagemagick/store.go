@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.google.com/p/go.net/context"
+	"google.golang.org/cloud/storage"
+)
+
+// errNotFound is what Read returns when there is no such object.
+var errNotFound = errors.New("no such object")
+
+// objectStore is where we read uploaded images from and write renditions to.
+type objectStore interface {
+	Read(bucket, name string, max int64) ([]byte, error) // reads at most max+1 bytes, so you can tell it was too big
+	Write(bucket, name, contentType string, data []byte) error
+	List(bucket, prefix string) ([]string, error) // the names of every object in the bucket that start with prefix
+	Delete(bucket, name string) error
+}
+
+// gcsStore keeps objects in Google Cloud Storage.
+type gcsStore struct {
+	ctx context.Context
+}
+
+func (s gcsStore) Read(bucket, name string, max int64) ([]byte, error) {
+	r, err := storage.NewReader(s.ctx, bucket, name)
+	if err == storage.ErrObjectNotExists {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("storage reader: %v", err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, fmt.Errorf("read %v/%v: %v", bucket, name, err)
+	}
+	return b, nil
+}
+
+func (s gcsStore) Write(bucket, name, contentType string, data []byte) error {
+	w := storage.NewWriter(s.ctx, bucket, name, &storage.Object{ContentType: contentType})
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("new writer: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("close object writer: %v", err)
+	}
+	if _, err := w.Object(); err != nil {
+		return fmt.Errorf("write op: %v", err)
+	}
+	return nil
+}
+
+func (s gcsStore) List(bucket, prefix string) ([]string, error) {
+	var names []string
+	q := &storage.Query{Prefix: prefix}
+	for q != nil {
+		objs, err := storage.List(s.ctx, bucket, q)
+		if err != nil {
+			return nil, fmt.Errorf("list %v: %v", bucket, err)
+		}
+		for _, o := range objs.Results {
+			names = append(names, o.Name)
+		}
+		q = objs.Next
+	}
+	return names, nil
+}
+
+func (s gcsStore) Delete(bucket, name string) error {
+	if err := storage.Delete(s.ctx, bucket, name); err != nil {
+		return fmt.Errorf("delete %v/%v: %v", bucket, name, err)
+	}
+	return nil
+}
+
+// localStore keeps objects in a directory, as dir/bucket/name, so that we can run without GCS.
+type localStore string
+
+func (s localStore) path(bucket, name string) (string, error) {
+	if bucket == "" || name == "" || strings.Contains(bucket, "..") || strings.Contains(name, "..") {
+		return "", fmt.Errorf("bad object name %q/%q", bucket, name)
+	}
+	return filepath.Join(string(s), bucket, filepath.FromSlash(name)), nil
+}
+
+func (s localStore) Read(bucket, name string, max int64) ([]byte, error) {
+	p, err := s.path(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(io.LimitReader(f, max+1))
+}
+
+func (s localStore) Write(bucket, name, contentType string, data []byte) error {
+	p, err := s.path(bucket, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// Write somewhere else first, so nobody sees half an image.
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s localStore) List(bucket, prefix string) ([]string, error) {
+	root := filepath.Join(string(s), bucket)
+	var names []string
+	err := filepath.Walk(filepath.Join(root, filepath.Dir(filepath.FromSlash(prefix))), func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return err
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return names, err
+}
+
+func (s localStore) Delete(bucket, name string) error {
+	p, err := s.path(bucket, name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
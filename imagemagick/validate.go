@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gographics/imagick/imagick"
+)
+
+// rejection is an upload we won't make renditions of.  Trying again won't help, so it is deleted
+// and endpoints is told, rather than left for the notice to retry.
+type rejection struct {
+	reason string
+}
+
+func (r rejection) Error() string { return "rejected: " + r.reason }
+
+func reject(format string, args ...interface{}) error {
+	return rejection{fmt.Sprintf(format, args...)}
+}
+
+// What to do with an animated image
+const (
+	animatedFirst  = "first"  // keep the first frame, the default
+	animatedReject = "reject" // don't take it at all
+)
+
+// sniff is the format of the image in b from its magic bytes, or "" if it isn't one we take.
+// Anything else is never handed to ImageMagick, which would otherwise read any of the dozens of
+// formats it knows, some of them scripts.
+func sniff(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		return "webp"
+	case bytes.HasPrefix(b, []byte("GIF87a")) || bytes.HasPrefix(b, []byte("GIF89a")):
+		return "gif"
+	}
+	return ""
+}
+
+// readImage checks the upload in b and reads it into a new wand.  The size is checked from the
+// header before any pixels are decoded, so a small file that decompresses to something huge is
+// turned away cheaply.  Every frame of an animation is decoded, so they all count.
+func readImage(b []byte) (*imagick.MagickWand, error) {
+	if int64(len(b)) > *maxBytes {
+		return nil, reject("more than %d bytes", *maxBytes)
+	}
+	format := sniff(b)
+	if format == "" {
+		return nil, reject("not a format we take")
+	}
+
+	ping := imagick.NewMagickWand()
+	defer ping.Destroy()
+	if err := readFailed(format, ping.PingImageBlob(b)); err != nil {
+		return nil, err
+	}
+	frames := ping.GetNumberImages()
+	if frames == 0 {
+		return nil, reject("unreadable %v: no images", format)
+	}
+	if frames > 1 && *animated == animatedReject {
+		return nil, reject("animated %v", format)
+	}
+	var pixels uint64
+	ping.ResetIterator()
+	for ping.NextImage() {
+		w, h := ping.GetImageWidth(), ping.GetImageHeight()
+		if w == 0 || h == 0 {
+			return nil, reject("%dx%d is empty", w, h)
+		}
+		pixels += uint64(w) * uint64(h)
+		if pixels > *maxPixels {
+			return nil, reject("%d frames of up to %dx%d are too big", frames, w, h)
+		}
+	}
+
+	wand := imagick.NewMagickWand()
+	if err := readFailed(format, wand.ReadImageBlob(b)); err != nil {
+		wand.Destroy()
+		return nil, err
+	}
+	if wand.GetNumberImages() == 0 {
+		wand.Destroy()
+		return nil, reject("unreadable %v: no images", format)
+	}
+	if wand.GetNumberImages() > 1 {
+		wand.SetFirstIterator()
+		first := wand.GetImage()
+		wand.Destroy()
+		wand = first
+	}
+	return wand, nil
+}
+
+// readFailed is whether ImageMagick reading an upload, which returned err, failed.  A warning,
+// such as a bad EXIF tag in an otherwise good JPEG, still leaves us the image, so it's only
+// logged.  An image it can't decode is rejected; running out of memory or disk, or anything else
+// that may be ours, is worth another try.
+func readFailed(format string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*imagick.MagickWandException); !ok {
+		return fmt.Errorf("reading %v: %v", format, err)
+	}
+	kind := exceptionKind(err)
+	switch {
+	case kind < imagick.EXCEPTION_ERROR:
+		log.Printf("reading %v: %v", format, err)
+		return nil
+	case kind == imagick.ERROR_CORRUPT_IMAGE || kind == imagick.FATAL_ERROR_CORRUPT_IMAGE ||
+		kind == imagick.ERROR_CODER || kind == imagick.FATAL_ERROR_CODER:
+		return reject("unreadable %v: %v", format, err)
+	}
+	return fmt.Errorf("reading %v: %v", format, err)
+}
+
+// exceptionKinds are those readFailed tells apart.
+var exceptionKinds = []imagick.ExceptionType{
+	imagick.EXCEPTION_WARNING,
+	imagick.WARNING_TYPE, imagick.WARNING_OPTION, imagick.WARNING_DELEGATE,
+	imagick.WARNING_MISSING_DELEGATE, imagick.WARNING_CORRUPT_IMAGE, imagick.WARNING_FILE_OPEN,
+	imagick.WARNING_BLOB, imagick.WARNING_STREAM, imagick.WARNING_CACHE, imagick.WARNING_CODER,
+	imagick.WARNING_FILTER, imagick.WARNING_MODULE, imagick.WARNING_DRAW, imagick.WARNING_IMAGE,
+	imagick.WARNING_WAND, imagick.WARNING_RANDOM, imagick.WARNING_XSERVER, imagick.WARNING_MONITOR,
+	imagick.WARNING_REGISTRY, imagick.WARNING_CONFIGURE, imagick.WARNING_POLICY,
+	imagick.ERROR_CORRUPT_IMAGE, imagick.FATAL_ERROR_CORRUPT_IMAGE,
+	imagick.ERROR_CODER, imagick.FATAL_ERROR_CODER,
+}
+
+// exceptionKind is the kind of ImageMagick exception err is.  The wand keeps it to itself, so it
+// is read back from the start of the message, which is its name, or "UnknownError[n]" for the
+// few imagick has no name for.  Anything we can't make out counts as an error.
+func exceptionKind(err error) imagick.ExceptionType {
+	name := strings.SplitN(err.Error(), ":", 2)[0]
+	for _, k := range exceptionKinds {
+		if k.String() == name {
+			return k
+		}
+	}
+	var n int
+	if _, err := fmt.Sscanf(name, "UnknownError[%d]", &n); err == nil {
+		return imagick.ExceptionType(n)
+	}
+	return imagick.EXCEPTION_ERROR
+}
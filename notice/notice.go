@@ -84,7 +84,8 @@ func incomingImageHandler(w http.ResponseWriter, r *http.Request) error {
 		return fmt.Errorf("backend: %v", err)
 	}
 
-	if res.StatusCode != http.StatusOK {
+	// The backend answers as soon as it has queued the image.
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
 		b, err := httputil.DumpResponse(res, true)
 		if err != nil {
 			return fmt.Errorf("dump response: %v", err)